@@ -5,8 +5,6 @@ go 1.13
 require (
 	github.com/coreos/go-etcd v2.0.0+incompatible // indirect
 	github.com/fsnotify/fsnotify v1.4.9 // indirect
-	github.com/gammazero/deque v0.0.0-20200310222745-50fa758af896 // indirect
-	github.com/gammazero/workerpool v0.0.0-20200311205957-7b00833861c6
 	github.com/go-macaron/binding v1.1.0
 	github.com/go-macaron/inject v0.0.0-20200308113650-138e5925c53b // indirect
 	github.com/go-macaron/session v0.0.0-20200329073812-7d919ce6a8d2
@@ -1,6 +1,8 @@
 package agent
 
 import (
+	"fmt"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -55,6 +57,8 @@ var (
 	processWg sync.WaitGroup
 
 	Cluster *HACluster
+	// clusterMutex guards Cluster access from the API while it is initialized
+	clusterMutex sync.RWMutex
 
 	MaxWorkers int
 )
@@ -64,89 +68,165 @@ func SetLogger(l *logrus.Logger) {
 	log = l
 }
 
-func initCluster(master string, slave string) *HACluster {
+func setCluster(c *HACluster) {
+	clusterMutex.Lock()
+	defer clusterMutex.Unlock()
+	Cluster = c
+}
+
+// GetCluster returns the running cluster, nil until the action has initialized it
+func GetCluster() *HACluster {
+	clusterMutex.RLock()
+	defer clusterMutex.RUnlock()
+	return Cluster
+}
+
+// clusterSlaves returns the slave nodes for the HA cluster, all nodes in
+// cluster-nodes except the master, or the slave if not configured
+func clusterSlaves(master string, slave string) []string {
+	nodes := MainConfig.General.ClusterNodes
+	if len(nodes) == 0 {
+		return []string{slave}
+	}
+	slaves := []string{}
+	for _, n := range nodes {
+		if n != master {
+			slaves = append(slaves, n)
+		}
+	}
+	return slaves
+}
+
+func findInfluxDB(name string) *config.InfluxDB {
+	for _, idb := range MainConfig.InfluxArray {
+		if idb.Name == name {
+			return idb
+		}
+	}
+	return nil
+}
+
+// initCluster builds the cluster waiting until all nodes answer
+func initCluster(master string, slaves ...string) *HACluster {
+	return buildCluster(true, master, slaves...)
+}
+
+// initHACluster builds the cluster without waiting for the nodes, the ones
+// not answering begin as down and are handled by the supervisor
+func initHACluster(master string, slaves ...string) *HACluster {
+	return buildCluster(false, master, slaves...)
+}
+
+func buildCluster(wait bool, master string, slaves ...string) *HACluster {
 
 	if len(master) == 0 {
 		master = MainConfig.General.MasterDB
 	}
-	if len(slave) == 0 {
-		slave = MainConfig.General.SlaveDB
+	if len(slaves) == 0 || (len(slaves) == 1 && len(slaves[0]) == 0) {
+		slaves = []string{MainConfig.General.SlaveDB}
 	}
 
 	log.Infof("Initializing cluster")
 
-	var MDB *InfluxMonitor
-	var SDB *InfluxMonitor
+	names := append([]string{master}, slaves...)
 
 	for {
-		slaveFound := false
-		masterAlive := true
-		masterFound := false
-		slaveAlive := true
-
-		for _, idb := range MainConfig.InfluxArray {
-			if idb.Name == master {
-				masterFound = true
-				log.Infof("Found MasterDB[%s] in config File %+v", master, idb)
-				MDB = &InfluxMonitor{cfg: idb, CheckInterval: MainConfig.General.CheckInterval}
-
-				cli, _, _, err := MDB.InitPing()
-				if err != nil {
-					masterAlive = false
-					log.Errorf("MasterDB[%s] has  problems :%s", master, err)
-				}
-				MDB.SetCli(cli)
+		allFound := true
+		allAlive := true
+		nodes := make([]*HANode, 0, len(names))
+
+		for k, name := range names {
+			role := "SlaveDB"
+			if k == 0 {
+				role = "MasterDB"
+			}
+			idb := findInfluxDB(name)
+			if idb == nil {
+				allFound = false
+				log.Errorf("No %s [%s] found, please check config and restart the process", role, name)
+				continue
 			}
-			if idb.Name == slave {
-				slaveFound = true
-				log.Infof("Found SlaveDB[%s] in config File %+v", slave, idb)
-				SDB = &InfluxMonitor{cfg: idb, CheckInterval: MainConfig.General.CheckInterval}
-
-				cli, _, _, err := SDB.InitPing()
-				if err != nil {
-					slaveAlive = false
-					log.Errorf("SlaveDB[%s] has  problems :%s", slave, err)
-				}
-				SDB.SetCli(cli)
+			log.Infof("Found %s[%s] in config File %+v", role, name, idb)
+			im := &InfluxMonitor{
+				cfg:           idb,
+				CheckInterval: MainConfig.General.CheckInterval,
+				DownThreshold: MainConfig.General.DownThreshold,
+				UpThreshold:   MainConfig.General.UpThreshold,
 			}
 
+			cli, dur, ver, err := im.InitPing()
+			if err != nil {
+				allAlive = false
+				log.Errorf("%s[%s] has  problems :%s", role, name, err)
+			} else {
+				// begin as UP without waiting for UpThreshold checks
+				im.numOKs = im.UpThreshold
+				im.setStatOK(dur, ver)
+			}
+			im.SetCli(cli)
+			nodes = append(nodes, &HANode{Monitor: im, StateOK: err == nil, LastOK: time.Now()})
 		}
 
-		if slaveFound && masterFound && masterAlive && slaveAlive {
+		if allFound && (allAlive || !wait) {
+			chunk := MainConfig.General.DataChunkDuration
+			if MainConfig.General.DataChunkTargetPoints > 0 {
+				// on adaptive mode measurements are split in smaller chunks as needed
+				chunk = MainConfig.General.DataChunkMaxDuration
+			}
 			return &HACluster{
-				Master:               MDB,
-				Slave:                SDB,
+				Master:               nodes[0].Monitor,
+				Slave:                nodes[1].Monitor,
+				Nodes:                nodes,
 				CheckInterval:        MainConfig.General.MinSyncInterval,
-				ClusterState:         "OK",
-				SlaveStateOK:         true,
-				SlaveLastOK:          time.Now(),
-				MasterStateOK:        true,
-				MasterLastOK:         time.Now(),
+				RecoveryPrePadding:   MainConfig.General.RecoveryPrePadding,
+				RecoveryPostPadding:  MainConfig.General.RecoveryPostPadding,
+				RecoveryMaxRetries:   MainConfig.General.RecoveryMaxRetries,
+				SyncMode:             MainConfig.General.SyncMode,
+				ClusterState:         ClusterStateOK,
 				MaxRetentionInterval: MainConfig.General.MaxRetentionInterval,
-				ChunkDuration:        MainConfig.General.DataChunkDuration,
-			}
-
-		} else {
-			if !slaveFound {
-				log.Errorf("No Slave DB  found, please check config and restart the process")
-			}
-			if !masterFound {
-				log.Errorf("No Master DB found, please check config and restart the process")
-			}
-			if !masterAlive {
-				log.Errorf("Master DB is not runing I should wait until both up to begin to chek sync status")
-			}
-			if !slaveAlive {
-				log.Errorf("Slave DB is not runing I should wait until both up to begin to chek sync status")
+				ChunkDuration:        chunk,
+				DataDir:              MainConfig.General.DataDir,
 			}
 		}
+		if !allAlive {
+			log.Errorf("Some cluster DB is not runing I should wait until all up to begin to chek sync status")
+		}
 		time.Sleep(MainConfig.General.MonitorRetryInterval)
 	}
 }
 
+// initCheckpoint sets the checkpoint in the data dir where the copy progress
+// is saved, resuming the previous one if resume is true
+func initCheckpoint(resume bool) bool {
+	file := filepath.Join(MainConfig.General.DataDir, fmt.Sprintf("checkpoint-%s-%s.json", Cluster.Master.cfg.Name, Cluster.Slave.cfg.Name))
+	cp, err := NewCheckpoint(file, resume)
+	if err != nil {
+		log.Errorf("Can not copy data , error on load checkpoint %s: %s", file, err)
+		return false
+	}
+	Cluster.Checkpoint = cp
+	return true
+}
+
+// endCheckpoint removes the checkpoint file once the copy is complete, it
+// is kept to resume the copy if it was cancelled or some chunks failed
+func endCheckpoint(err error) {
+	cp := Cluster.Checkpoint
+	if cp == nil {
+		return
+	}
+	if err != nil {
+		log.Warnf("CHECKPOINT: copy not complete (%s), keeping %s to resume it", err, cp.file)
+		return
+	}
+	if err := cp.Remove(); err != nil {
+		log.Errorf("CHECKPOINT: error on remove %s: %s", cp.file, err)
+	}
+}
+
 func ReplSch(master string, slave string, dbs string, newdb string, rps string, newrp string, meas string) {
 
-	Cluster = initCluster(master, slave)
+	setCluster(initCluster(master, slave))
 
 	schema, err := Cluster.GetSchema(dbs, rps, meas)
 	if err != nil {
@@ -173,9 +253,12 @@ func ReplSch(master string, slave string, dbs string, newdb string, rps string,
 
 }
 
-func SchCopy(master string, slave string, dbs string, newdb string, rps string, newrp string, meas string, start time.Time, end time.Time, full bool) {
+func SchCopy(master string, slave string, dbs string, newdb string, rps string, newrp string, meas string, start time.Time, end time.Time, full bool, resume bool) {
 
-	Cluster = initCluster(master, slave)
+	setCluster(initCluster(master, slave))
+	if !initCheckpoint(resume) {
+		return
+	}
 
 	schema, err := Cluster.GetSchema(dbs, rps, meas)
 	if err != nil {
@@ -195,21 +278,37 @@ func SchCopy(master string, slave string, dbs string, newdb string, rps string,
 		}
 	}
 
+	if err := checkWhere(Cluster.Master.cli, schema); err != nil {
+		log.Errorf("Can not copy data , invalid where filter: %s", err)
+		return
+	}
+
+	if err := checkDownsample(schema); err != nil {
+		log.Errorf("Can not copy data , invalid downsample source: %s", err)
+		return
+	}
+
+	ctx, done := startJob(fmt.Sprintf("fullcopy %s -> %s", Cluster.Master.cfg.Name, Cluster.Slave.cfg.Name))
+	defer done()
 	s := time.Now()
 	Cluster.ReplicateSchema(schema)
 	if full {
-		Cluster.ReplicateDataFull(schema)
+		err = Cluster.ReplicateDataFull(ctx, schema)
 	} else {
-		Cluster.ReplicateData(schema, start, end)
+		err = Cluster.ReplicateData(ctx, schema, start, end)
 	}
 	elapsed := time.Since(s)
 	log.Infof("Copy take: %s", elapsed.String())
+	endCheckpoint(err)
 
 }
 
-func Copy(master string, slave string, dbs string, newdb string, rps string, newrp string, meas string, start time.Time, end time.Time, full bool) {
+func Copy(master string, slave string, dbs string, newdb string, rps string, newrp string, meas string, start time.Time, end time.Time, full bool, resume bool) {
 
-	Cluster = initCluster(master, slave)
+	setCluster(initCluster(master, slave))
+	if !initCheckpoint(resume) {
+		return
+	}
 
 	schema, err := Cluster.GetSchema(dbs, rps, meas)
 	if err != nil {
@@ -228,53 +327,126 @@ func Copy(master string, slave string, dbs string, newdb string, rps string, new
 		}
 	}
 
+	if err := checkWhere(Cluster.Master.cli, schema); err != nil {
+		log.Errorf("Can not copy data , invalid where filter: %s", err)
+		return
+	}
+
+	if err := checkDownsample(schema); err != nil {
+		log.Errorf("Can not copy data , invalid downsample source: %s", err)
+		return
+	}
+
+	ctx, done := startJob(fmt.Sprintf("copy %s -> %s", Cluster.Master.cfg.Name, Cluster.Slave.cfg.Name))
+	defer done()
 	s := time.Now()
 	if full {
-		Cluster.ReplicateDataFull(schema)
+		err = Cluster.ReplicateDataFull(ctx, schema)
 	} else {
-		Cluster.ReplicateData(schema, start, end)
+		err = Cluster.ReplicateData(ctx, schema, start, end)
 	}
 	elapsed := time.Since(s)
 	log.Infof("Copy take: %s", elapsed.String())
+	endCheckpoint(err)
+
+}
+
+// Reconcile compares master and slave data and copies only the windows that differ
+func Reconcile(master string, slave string, dbs string, newdb string, rps string, newrp string, meas string, start time.Time, end time.Time, full bool) {
+
+	setCluster(initCluster(master, slave))
+
+	schema, err := Cluster.GetSchema(dbs, rps, meas)
+	if err != nil {
+		log.Errorf("Can not reconcile data , error on get Schema: %s", err)
+		return
+	}
+
+	if len(newdb) > 0 && len(schema) > 0 {
+		for p := range schema {
+			schema[p].NewName = newdb
+		}
+	}
+	if len(newrp) > 0 && len(schema) > 0 {
+		for p := range schema {
+			schema[p].NewDefRp = newrp
+		}
+	}
+
+	if err := checkWhere(Cluster.Master.cli, schema); err != nil {
+		log.Errorf("Can not reconcile data , invalid where filter: %s", err)
+		return
+	}
+
+	ctx, done := startJob(fmt.Sprintf("reconcile %s -> %s", Cluster.Master.cfg.Name, Cluster.Slave.cfg.Name))
+	defer done()
+	s := time.Now()
+	if full {
+		Cluster.ReconcileDataFull(ctx, schema)
+	} else {
+		Cluster.ReconcileData(ctx, schema, start, end)
+	}
+	elapsed := time.Since(s)
+	log.Infof("Reconcile take: %s", elapsed.String())
 
 }
 
 func HAMonitorStart(master string, slave string) {
 
-	Cluster = initCluster(master, slave)
-
-	schema, _ := Cluster.GetSchema("", "", "")
-
-	switch MainConfig.General.InitialReplication {
-	case "schema":
-		log.Info("Replicating DB Schema from Master to Slave")
-		Cluster.ReplicateSchema(schema)
-	case "data":
-		log.Info("Replicating DATA Schema from Master to Slave")
-		Cluster.ReplicateDataFull(schema)
-	case "both":
-		log.Info("Replicating DB Schema from Master to Slave")
-		Cluster.ReplicateSchema(schema)
-		log.Info("Replicating DATA Schema from Master to Slave")
-		Cluster.ReplicateDataFull(schema)
-	case "none":
-		log.Info("No replication done")
-	default:
-		log.Errorf("Unknown replication config %s", MainConfig.General.InitialReplication)
-	}
-
-	Cluster.Master.StartMonitor(&processWg)
-	Cluster.Slave.StartMonitor(&processWg)
+	setCluster(initHACluster(master, clusterSlaves(master, slave)...))
+
+	if err := Cluster.LoadState(); err != nil {
+		log.Errorf("Error on load previous cluster state: %s", err)
+	}
+	Cluster.openDownOutages()
+	Cluster.RetryQueue = NewRetryQueue(MainConfig.General.DataDir, MainConfig.General.RetryInterval, MainConfig.General.RetryMaxBackoff)
+
+	var schema []*InfluxSchDb
+	if Cluster.Nodes[0].StateOK {
+		schema, _ = Cluster.GetSchema("", "", "")
+	}
+
+	for _, n := range Cluster.Nodes[1:] {
+		if !Cluster.Nodes[0].StateOK || !n.StateOK {
+			log.Warnf("No initial replication done on Slave %s, master or slave is down", n.ID())
+			continue
+		}
+		ctx, done := startJob(fmt.Sprintf("initial replication %s -> %s", Cluster.Master.cfg.Name, n.ID()))
+		switch MainConfig.General.InitialReplication {
+		case "schema":
+			log.Infof("Replicating DB Schema from Master to Slave %s", n.ID())
+			replicateSchema(n.Monitor, schema)
+		case "data":
+			log.Infof("Replicating DATA Schema from Master to Slave %s", n.ID())
+			Cluster.replicateDataFull(ctx, Cluster.Master, n.Monitor, schema)
+		case "both":
+			log.Infof("Replicating DB Schema from Master to Slave %s", n.ID())
+			replicateSchema(n.Monitor, schema)
+			log.Infof("Replicating DATA Schema from Master to Slave %s", n.ID())
+			Cluster.replicateDataFull(ctx, Cluster.Master, n.Monitor, schema)
+		case "none":
+			log.Infof("No replication done on Slave %s", n.ID())
+		default:
+			log.Errorf("Unknown replication config %s", MainConfig.General.InitialReplication)
+		}
+		done()
+	}
+
+	for _, n := range Cluster.Nodes {
+		n.Monitor.StartMonitor(&processWg)
+	}
 	time.Sleep(MainConfig.General.CheckInterval)
 	Cluster.SuperVisor(&processWg)
+	Cluster.RetryQueueStart(&processWg)
 
 }
 
-// End stops all devices polling.
+// End cancels all the running jobs and waits until their running batches
+// finish and their progress is saved
 func End() (time.Duration, error) {
 
 	start := time.Now()
-	//nothing to do
+	cancelJobs()
 	return time.Since(start), nil
 }
 
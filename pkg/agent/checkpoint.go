@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CheckpointRange is the time range to copy for a database/retention policy
+type CheckpointRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Checkpoint keeps the progress of copy actions to resume them if the process dies
+type Checkpoint struct {
+	file  string
+	mutex sync.Mutex
+	// saveMutex serializes the file writes, progress is encoded holding only mutex
+	saveMutex sync.Mutex
+	// Ranges are the copy time ranges for each db/rp, needed to get the same chunks on resume
+	Ranges map[string]*CheckpointRange
+	// Progress are the merged [start,end) nanosecond periods already copied for each db/rp/measurement
+	Progress map[string][][2]int64
+}
+
+// NewCheckpoint creates a checkpoint saved on file, if resume is true
+// the progress previously saved on file is loaded
+func NewCheckpoint(file string, resume bool) (*Checkpoint, error) {
+	cp := &Checkpoint{
+		file:     file,
+		Ranges:   make(map[string]*CheckpointRange),
+		Progress: make(map[string][][2]int64),
+	}
+	if !resume {
+		return cp, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		log.Warnf("CHECKPOINT: no checkpoint file %s found, nothing to resume", file)
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, err
+	}
+	if cp.Progress == nil {
+		cp.Progress = make(map[string][][2]int64)
+	}
+	log.Infof("CHECKPOINT: resuming from %s with %d measurements in progress", file, len(cp.Progress))
+	return cp, nil
+}
+
+// Range returns the saved time range for db/rp or saves start/end as its range
+func (cp *Checkpoint) Range(db string, rp string, start time.Time, end time.Time) (time.Time, time.Time) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	key := db + "|" + rp
+	if r, ok := cp.Ranges[key]; ok {
+		log.Infof("CHECKPOINT: resuming DB %s RP %s FROM [ %s ] TO [ %s ]", db, rp, r.Start.String(), r.End.String())
+		return r.Start, r.End
+	}
+	cp.Ranges[key] = &CheckpointRange{Start: start, End: end}
+	return start, end
+}
+
+func measKey(sr *SyncReport, meas string) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s", sr.SrcSrv, sr.DstSrv, sr.SrcDB, sr.SrcRP, sr.DstDB, sr.DstRP, meas)
+}
+
+// IsDone returns true if the [start,end) period of the key measurement has already been copied
+func (cp *Checkpoint) IsDone(key string, start int64, end int64) bool {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	for _, r := range cp.Progress[key] {
+		if r[0] <= start && end <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// SetDone marks the [start,end) period of the key measurement as copied,
+// merging it with the overlapped or adjacent periods already done
+func (cp *Checkpoint) SetDone(key string, start int64, end int64) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	ranges := make([][2]int64, 0, len(cp.Progress[key])+1)
+	for _, r := range cp.Progress[key] {
+		if r[1] < start || end < r[0] {
+			ranges = append(ranges, r)
+			continue
+		}
+		if r[0] < start {
+			start = r[0]
+		}
+		if r[1] > end {
+			end = r[1]
+		}
+	}
+	ranges = append(ranges, [2]int64{start, end})
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	cp.Progress[key] = ranges
+}
+
+// Save writes the checkpoint to its file
+func (cp *Checkpoint) Save() error {
+	cp.mutex.Lock()
+	data, err := json.Marshal(cp)
+	cp.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	// concurrent saves could write an older progress last, it only
+	// makes some already copied chunks to be copied again on resume
+	cp.saveMutex.Lock()
+	defer cp.saveMutex.Unlock()
+	if err := os.MkdirAll(filepath.Dir(cp.file), 0755); err != nil {
+		return err
+	}
+	tmp := cp.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.file)
+}
+
+// Remove deletes the checkpoint file, once the copy is complete there is nothing to resume
+func (cp *Checkpoint) Remove() error {
+	cp.saveMutex.Lock()
+	defer cp.saveMutex.Unlock()
+	if err := os.Remove(cp.file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
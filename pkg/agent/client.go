@@ -1,13 +1,18 @@
 package agent
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"sort"
 	"strconv"
+	"strings"
 
 	"encoding/json"
 
 	"time"
 
+	"github.com/influxdata/influxdb1-client/models"
 	"github.com/influxdata/influxdb1-client/v2"
 	"github.com/toni-moreno/syncflux/pkg/agent/try"
 )
@@ -260,14 +265,116 @@ func GetFields(c client.Client, sdb string, meas string, rp string) map[string]*
 		for _, row := range values {
 			fieldname := row[0].(string)
 			fieldtype := row[1].(string)
-			fields[fieldname] = &FieldSch{Name: fieldname, Type: fieldtype}
 			log.Debugf("Detected Field [%s] type [%s] on measurement [%s]", fieldname, fieldtype, meas)
+			// fields with different type in some shards are returned once for each type
+			if f, ok := fields[fieldname]; ok {
+				known := f.Type == fieldtype
+				for _, t := range f.Types {
+					known = known || t == fieldtype
+				}
+				if !known {
+					if len(f.Types) == 0 {
+						f.Types = []string{f.Type}
+					}
+					f.Types = append(f.Types, fieldtype)
+				}
+				continue
+			}
+			fields[fieldname] = &FieldSch{Name: fieldname, Type: fieldtype}
 		}
 
 	}
 	return fields
 }
 
+// GetShardGroups returns the [start,end) nanosecond periods of the sdb.rp shard groups sorted by start time
+func GetShardGroups(c client.Client, sdb string, rp string) ([][2]int64, error) {
+	q := client.Query{
+		Command: "show shard groups",
+	}
+
+	if err := waitQuery(); err != nil {
+		return nil, err
+	}
+	response, err := c.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	if response.Error() != nil {
+		return nil, response.Error()
+	}
+
+	groups := [][2]int64{}
+	for _, res := range response.Results {
+		for _, ser := range res.Series {
+			col := make(map[string]int, len(ser.Columns))
+			for i, name := range ser.Columns {
+				col[name] = i
+			}
+			for _, row := range ser.Values {
+				if fmt.Sprint(row[col["database"]]) != sdb || fmt.Sprint(row[col["retention_policy"]]) != rp {
+					continue
+				}
+				start, err := time.Parse(time.RFC3339Nano, fmt.Sprint(row[col["start_time"]]))
+				if err != nil {
+					return nil, err
+				}
+				end, err := time.Parse(time.RFC3339Nano, fmt.Sprint(row[col["end_time"]]))
+				if err != nil {
+					return nil, err
+				}
+				groups = append(groups, [2]int64{start.UnixNano(), end.UnixNano()})
+			}
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return groups, nil
+}
+
+// GetFieldTypesByTime returns the type of each field of measurement meas in the shards with data
+// in the [start,end) period, querying the _fieldKeys system source as SHOW FIELD KEYS does
+func GetFieldTypesByTime(c client.Client, sdb string, rp string, meas string, start time.Time, end time.Time) (map[string]string, error) {
+
+	cmd := fmt.Sprintf("select fieldKey, fieldType from \"%s\".\"_fieldKeys\" where \"_name\" = '%s' and time >= %d and time < %d", rp, strings.Replace(meas, "'", "\\'", -1), start.UnixNano(), end.UnixNano())
+	q := client.Query{
+		Command:  cmd,
+		Database: sdb,
+	}
+
+	if err := waitQuery(); err != nil {
+		return nil, err
+	}
+	response, err := c.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	if response.Error() != nil {
+		return nil, response.Error()
+	}
+
+	types := make(map[string]string)
+	for _, res := range response.Results {
+		for _, ser := range res.Series {
+			for _, row := range ser.Values {
+				// row[0] is the time column
+				if len(row) < 3 {
+					continue
+				}
+				name, ok1 := row[1].(string)
+				typ, ok2 := row[2].(string)
+				if !ok1 || !ok2 {
+					continue
+				}
+				if t, ok := types[name]; ok && t != typ {
+					return nil, fmt.Errorf("field %s has types %s and %s in the same period", name, t, typ)
+				}
+				types[name] = typ
+			}
+		}
+	}
+	return types, nil
+}
+
 func GetMeasurements(c client.Client, sdb string, rp string, mesafilter string) []*MeasurementSch {
 
 	cmd := "show measurements"
@@ -299,7 +406,7 @@ func GetMeasurements(c client.Client, sdb string, rp string, mesafilter string)
 
 		for _, row := range values {
 			measurement := fmt.Sprintf("%v", row[0])
-			measurements = append(measurements, &MeasurementSch{Name: measurement, Fields: nil})
+			measurements = append(measurements, &MeasurementSch{Name: measurement, NewName: transformMeasurement(measurement), Fields: nil})
 
 			time.Sleep(3 * time.Millisecond)
 		}
@@ -309,6 +416,182 @@ func GetMeasurements(c client.Client, sdb string, rp string, mesafilter string)
 
 }
 
+// CountPoints returns the number of points in measurement meas in the [start,end) period.
+// count(*) returns the number of values for each field, the number of points is the greatest one
+func CountPoints(c client.Client, sdb string, rp string, meas string, start time.Time, end time.Time) (int64, error) {
+
+	cmd := fmt.Sprintf("select count(*) from \"%s\" where time >= %d and time < %d", meas, start.UnixNano(), end.UnixNano())
+	q := client.Query{
+		Command:         cmd,
+		Database:        sdb,
+		RetentionPolicy: rp,
+	}
+
+	if err := waitQuery(); err != nil {
+		return 0, err
+	}
+	response, err := c.Query(q)
+	if err != nil {
+		return 0, err
+	}
+	if response.Error() != nil {
+		return 0, response.Error()
+	}
+
+	var count int64
+	for _, res := range response.Results {
+		for _, ser := range res.Series {
+			for _, row := range ser.Values {
+				for _, v := range row[1:] {
+					n, ok := v.(json.Number)
+					if !ok {
+						continue
+					}
+					i, err := n.Int64()
+					if err != nil {
+						return 0, err
+					}
+					if i > count {
+						count = i
+					}
+				}
+			}
+		}
+	}
+	return count, nil
+}
+
+// PointCount is the number of points in a group by time interval beginning at Time
+type PointCount struct {
+	Time  time.Time
+	Count int64
+}
+
+// CountPointsByTime returns the number of points in measurement meas in the [start,end) period
+// of the series matching the where filter grouped by time intervals of group duration, sorted by time
+func CountPointsByTime(c client.Client, sdb string, rp string, meas string, start time.Time, end time.Time, group time.Duration) ([]PointCount, error) {
+
+	cmd := fmt.Sprintf("select count(*) from \"%s\" where time >= %d and time < %d%s group by time(%ds)", meas, start.UnixNano(), end.UnixNano(), whereClause(), int64(group.Seconds()))
+	q := client.Query{
+		Command:         cmd,
+		Database:        sdb,
+		RetentionPolicy: rp,
+		Precision:       "s",
+	}
+
+	if err := waitQuery(); err != nil {
+		return nil, err
+	}
+	response, err := c.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	if response.Error() != nil {
+		return nil, response.Error()
+	}
+
+	counts := []PointCount{}
+	for _, res := range response.Results {
+		for _, ser := range res.Series {
+			for _, row := range ser.Values {
+				t, ok := row[0].(json.Number)
+				if !ok {
+					continue
+				}
+				sec, err := t.Int64()
+				if err != nil {
+					return nil, err
+				}
+				pc := PointCount{Time: time.Unix(sec, 0)}
+				for _, v := range row[1:] {
+					n, ok := v.(json.Number)
+					if !ok {
+						continue
+					}
+					i, err := n.Int64()
+					if err != nil {
+						return nil, err
+					}
+					if i > pc.Count {
+						pc.Count = i
+					}
+				}
+				counts = append(counts, pc)
+			}
+		}
+	}
+	return counts, nil
+}
+
+// DigestByTime returns a digest of the points in measurement meas in the [start,end) period of the
+// series matching the where filter for each group by time interval with points, made with the count of all fields and the
+// sum of the numeric ones, indexed by the interval start time in seconds
+func DigestByTime(c client.Client, sdb string, rp string, meas string, fields map[string]*FieldSch, start time.Time, end time.Time, group time.Duration) (map[int64]string, error) {
+
+	names := []string{}
+	for name, f := range fields {
+		if f.Skip {
+			continue
+		}
+		switch f.Type {
+		case "float", "integer", "unsigned":
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	sel := []string{"count(*)"}
+	for _, name := range names {
+		sel = append(sel, fmt.Sprintf("sum(\"%s\")", name))
+	}
+
+	cmd := fmt.Sprintf("select %s from \"%s\" where time >= %d and time < %d%s group by time(%ds)", strings.Join(sel, ","), meas, start.UnixNano(), end.UnixNano(), whereClause(), int64(group.Seconds()))
+	q := client.Query{
+		Command:         cmd,
+		Database:        sdb,
+		RetentionPolicy: rp,
+		Precision:       "s",
+	}
+
+	if err := waitQuery(); err != nil {
+		return nil, err
+	}
+	response, err := c.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	if response.Error() != nil {
+		return nil, response.Error()
+	}
+
+	digests := make(map[int64]string)
+	for _, res := range response.Results {
+		for _, ser := range res.Series {
+			for _, row := range ser.Values {
+				t, ok := row[0].(json.Number)
+				if !ok {
+					continue
+				}
+				sec, err := t.Int64()
+				if err != nil {
+					return nil, err
+				}
+				// skip empty intervals
+				empty := true
+				for i, v := range row[1:] {
+					if strings.HasPrefix(ser.Columns[i+1], "count") && fmt.Sprint(v) != "0" {
+						empty = false
+					}
+				}
+				if empty {
+					continue
+				}
+				digests[sec] = fmt.Sprint(row[1:]...)
+			}
+		}
+	}
+	return digests, nil
+}
+
 func UnixNano2Time(tstamp int64) (time.Time, error) {
 	sec := tstamp / 1000000000
 	nsec := tstamp % 1000000000
@@ -318,7 +601,7 @@ func UnixNano2Time(tstamp int64) (time.Time, error) {
 func StrUnixNano2Time(tstamp string) (time.Time, error) {
 	i, err := strconv.ParseInt(tstamp, 10, 64)
 	if err != nil {
-		log.Errorf("Error on parse time [%s]", tstamp, err)
+		log.Errorf("Error on parse time [%s]: %s", tstamp, err)
 		return time.Now(), err
 	}
 	sec := i / 1000000000
@@ -326,11 +609,181 @@ func StrUnixNano2Time(tstamp string) (time.Time, error) {
 	return time.Unix(sec, nsec), nil
 }
 
-func ReadDB(c client.Client, sdb, srp, ddb, drp, cmd string, fieldmap map[string]*FieldSch) (client.BatchPoints, int64, error) {
+// rowPoint builds the point for the row v of the ser serie, returns nil if the row can not be processed
+func rowPoint(ser models.Row, v []interface{}, fieldmap map[string]*FieldSch) *client.Point {
+	var timestamp time.Time
+	var terr error
+
+	switch t := v[0].(type) {
+	case string:
+		timestamp, terr = StrUnixNano2Time(t)
+	case int64:
+		timestamp, terr = UnixNano2Time(t)
+	case json.Number:
+		i, _ := t.Int64()
+		timestamp, terr = UnixNano2Time(i)
+	default:
+		log.Warnf("Timestamp type is %T [%#+v]", t, t)
+		return nil
+	}
+	if terr != nil {
+		log.Errorf("Error processing timestamp skipping point for measurements %s", ser.Name)
+		return nil
+	}
+
+	field := make(map[string]interface{})
+	l := len(v)
+	for i := 1; i < l; i++ {
+		val := v[i]
+		tp := fieldmap[ser.Columns[i]]
+		if tp != nil && tp.Skip {
+			continue
+		}
+		if val != nil {
+			switch vt := val.(type) {
+			case json.Number:
+				if tp == nil {
+					// field created after the schema was read, its numeric type is unknown
+					log.Warnf("Unknown field %s in measurement %s skipped, not found in schema", ser.Columns[i], ser.Name)
+					continue
+				}
+				switch tp.Type {
+				case "float":
+					conv, err := vt.Float64()
+					if err != nil {
+						log.Errorf("Error on parse field %s data %#+v %T :%s", ser.Columns[i], val, vt, err)
+					}
+					field[ser.Columns[i]] = conv
+				case "integer":
+					conv, err := vt.Int64()
+					if err != nil {
+						log.Errorf("Error on parse field %s data %#+v %T :%s", ser.Columns[i], val, vt, err)
+					}
+					field[ser.Columns[i]] = conv
+				case "unsigned":
+					conv, err := strconv.ParseUint(vt.String(), 10, 64)
+					if err != nil {
+						log.Errorf("Error on parse field %s data %#+v %T :%s", ser.Columns[i], val, vt, err)
+					}
+					field[ser.Columns[i]] = conv
+				case "boolean":
+					fallthrough
+				case "string":
+					conv := vt.String()
+					field[ser.Columns[i]] = conv
+				default:
+					log.Warnf("Unhandled type %s in field %s measuerment %s", tp.Type, ser.Columns[i], ser.Name)
+				}
+			case string, bool, int64, float64:
+				// values of fields with type conflicts coerced to string
+				if tp != nil && len(tp.Types) > 0 && tp.Type == "string" {
+					field[ser.Columns[i]] = fmt.Sprint(v[i])
+					continue
+				}
+				field[ser.Columns[i]] = v[i]
+			default:
+				//Supposed to be ok
+				log.Warnf("Error unknown type %T on field %s don't know about type %T! value %#+v \n", vt, ser.Columns[i], vt, val)
+				field[ser.Columns[i]] = v[i]
+			}
+
+		}
+	}
+	name, tags, field := transformPoint(ser.Name, ser.Tags, field)
+	if len(field) == 0 {
+		// all its fields are skipped or dropped
+		return nil
+	}
+	log.Tracef("POINT TIME  [%s] - NOW[%s] | MEAS: %s | TAGS: %#+v | FIELDS: %#+v| ", timestamp.String(), time.Now().String(), name, tags, field)
+	point, err := client.NewPoint(name, tags, field, timestamp)
+	if err != nil {
+		log.Errorf("Error in set point %s", err)
+		return nil
+	}
+	return point
+}
+
+// streamQuery decodes each segment of the chunked response of q as it arrives and
+// calls write each time maxpoints points have been read (and with the last points)
+func streamQuery(ctx context.Context, c client.Client, q client.Query, bpcfg client.BatchPointsConfig, fieldmap map[string]*FieldSch, maxpoints int, write func(client.BatchPoints) error) (int64, error) {
+	var totalpoints int64
+
+	if err := queriesLimit.wait(ctx, 1); err != nil {
+		return 0, err
+	}
+	response, err := c.QueryAsChunk(q)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Close()
+
+	batchpoints, err := client.NewBatchPoints(bpcfg)
+	if err != nil {
+		return 0, err
+	}
+	flush := func() error {
+		if len(batchpoints.Points()) == 0 {
+			return nil
+		}
+		if err := write(batchpoints); err != nil {
+			return err
+		}
+		batchpoints, err = client.NewBatchPoints(bpcfg)
+		return err
+	}
+
+	for {
+		// stop reading on cancel, already read points are written
+		if err := ctx.Err(); err != nil {
+			if ferr := flush(); ferr != nil {
+				return totalpoints, ferr
+			}
+			return totalpoints, err
+		}
+		resp, err := response.NextResponse()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return totalpoints, err
+		}
+		if err := resp.Error(); err != nil {
+			return totalpoints, err
+		}
+		for k, res := range resp.Results {
+			//show progress of reading series
+			log.Tracef("Reading %d Series for db %s", len(res.Series), q.Database)
+			for _, ser := range res.Series {
+				log.Tracef("ROW Result [%d] [%#+v]", k, ser)
+				for _, v := range ser.Values {
+					point := rowPoint(ser, v, fieldmap)
+					if point == nil {
+						continue
+					}
+					batchpoints.AddPoint(point)
+					totalpoints++
+					if len(batchpoints.Points()) >= maxpoints {
+						if err := flush(); err != nil {
+							return totalpoints, err
+						}
+					}
+				}
+			}
+		}
+	}
+	return totalpoints, flush()
+}
+
+// ReadDB runs the cmd query on sdb/srp and streams the result as batches of
+// max-points-on-single-write points for ddb/drp to write, so the whole
+// query result is never buffered. Read errors are retried from the beginning
+// of the query (rewriting the same points is harmless), errors returned by
+// write stop the read and are returned as they are.
+func ReadDB(ctx context.Context, c client.Client, sdb, srp, ddb, drp, cmd string, fieldmap map[string]*FieldSch, write func(client.BatchPoints) error) (int64, error) {
 	var totalpoints int64
 	RWMaxRetries := MainConfig.General.RWMaxRetries
 	RWRetryDelay := MainConfig.General.RWRetryDelay
-	totalpoints = 0
+	MaxPointsOnSingleWrite := MainConfig.General.MaxPointsOnSingleWrite
 
 	//param := make(map[string]interface{})
 	//param["wait_for_leader"] = "2000s"
@@ -351,137 +804,48 @@ func ReadDB(c client.Client, sdb, srp, ddb, drp, cmd string, fieldmap map[string
 		Precision:       "ns",
 	}
 
-	batchpoints, err := client.NewBatchPoints(bpcfg)
-	if err != nil {
-		log.Error("Error on create BatchPoints: %s", err)
-		return batchpoints, 0, err
+	var werr error
+	wr := func(bp client.BatchPoints) error {
+		werr = write(bp)
+		return werr
 	}
-	var response *client.Response
 
 	//Retry query if some error happens
 
-	err = try.Do(func(attempt int) (bool, error) {
+	err := try.Do(func(attempt int) (bool, error) {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
 		var qerr error
 		s := time.Now()
-		response, qerr = c.Query(q)
+		totalpoints, qerr = streamQuery(ctx, c, q, bpcfg, fieldmap, MaxPointsOnSingleWrite, wr)
 		elapsed := time.Since(s)
 		log.Debugf("Query [%s] took %s ", cmd, elapsed.String())
-		if qerr != nil {
+		if werr != nil {
+			return false, werr
+		}
+		if qerr != nil && ctx.Err() == nil {
 			log.Warnf("Fail to get response from query %s on [%s|%s] in attempt %d / read database error: %s", cmd, sdb, srp, attempt, qerr)
 			log.Warnf("Trying again... in %s sec", RWRetryDelay.String())
-			time.Sleep(RWRetryDelay) // wait a minute
+			if err := sleepCtx(ctx, RWRetryDelay); err != nil {
+				return false, err
+			}
 		}
 
 		return attempt < RWMaxRetries, qerr
 
 	})
+	if werr != nil {
+		return totalpoints, werr
+	}
+	if ctx.Err() != nil {
+		return totalpoints, ctx.Err()
+	}
 	if err != nil {
 		log.Errorf("Max Retries (%d) exceeded on read Data: Last error %s ", RWMaxRetries, err)
-		return nil, 0, err
+		return totalpoints, err
 	}
-
-	res := response.Results
-	if len(res) == 0 {
-		log.Warnf("The response of query [%s] is void, read database error!\n", cmd)
-	} else {
-		resLength := len(res)
-		for k := 0; k < resLength; k++ {
-
-			//show progress of reading series
-			log.Tracef("Reading %d Series for db %s", len(res[k].Series), sdb)
-			for _, ser := range res[k].Series {
-				log.Tracef("ROW Result [%d] [%#+v]", k, ser)
-
-				for _, v := range ser.Values {
-
-					var timestamp time.Time
-					var terr error
-
-					switch t := v[0].(type) {
-					case string:
-						timestamp, terr = StrUnixNano2Time(t)
-						if terr != nil {
-							log.Errorf("Error processing timestamp skipping point %d for measurements %s", k, ser.Name)
-							continue
-						}
-					case int64:
-						timestamp, terr = UnixNano2Time(t)
-						if terr != nil {
-							log.Errorf("Error processing timestamp skipping point %d for measurements %s", k, ser.Name)
-							continue
-						}
-					case json.Number:
-						i, _ := t.Int64()
-						timestamp, terr = UnixNano2Time(i)
-						if terr != nil {
-							log.Errorf("Error processing timestamp skipping point %d for measurements %s", k, ser.Name)
-							continue
-						}
-					default:
-						log.Warnf("Timestamp type is %T [%#+v]", t, t)
-						continue
-					}
-
-					field := make(map[string]interface{})
-					l := len(v)
-					for i := 1; i < l; i++ {
-						val := v[i]
-						if val != nil {
-							switch vt := val.(type) {
-							case json.Number:
-								tp := fieldmap[ser.Columns[i]]
-								switch tp.Type {
-								case "float":
-									conv, err := vt.Float64()
-									if err != nil {
-										log.Errorf("Error on parse field %s data %#+v %T :%s", ser.Columns[i], val, vt, err)
-									}
-									field[ser.Columns[i]] = conv
-								case "integer":
-									conv, err := vt.Int64()
-									if err != nil {
-										log.Errorf("Error on parse field %s data %#+v %T :%s", ser.Columns[i], val, vt, err)
-									}
-									field[ser.Columns[i]] = conv
-								case "unsigned":
-									conv, err := strconv.ParseUint(vt.String(), 10, 64)
-									if err != nil {
-										log.Errorf("Error on parse field %s data %#+v %T :%s", ser.Columns[i], val, vt, err)
-									}
-									field[ser.Columns[i]] = conv
-								case "boolean":
-									fallthrough
-								case "string":
-									conv := vt.String()
-									field[ser.Columns[i]] = conv
-								default:
-									log.Warnf("Unhandled type %s in field %s measuerment %s", tp, ser.Columns[i], ser.Name)
-								}
-							case string, bool, int64, float64:
-								field[ser.Columns[i]] = v[i]
-							default:
-								//Supposed to be ok
-								log.Warnf("Error unknown type %T on field %s don't know about type %T! value %#+v \n", vt, ser.Columns[i], vt)
-								field[ser.Columns[i]] = v[i]
-							}
-
-						}
-					}
-					log.Tracef("POINT TIME  [%s] - NOW[%s] | MEAS: %s | TAGS: %#+v | FIELDS: %#+v| ", timestamp.String(), time.Now().String(), ser.Name, ser.Tags, field)
-					point, err := client.NewPoint(ser.Name, ser.Tags, field, timestamp)
-					if err != nil {
-						log.Errorf("Error in set point %s", err)
-						continue
-					}
-					batchpoints.AddPoint(point)
-					totalpoints++
-				}
-
-			}
-		}
-
-	}
-	return batchpoints, totalpoints, nil
+	return totalpoints, nil
 }
 
 func min(a, b int) int {
@@ -495,10 +859,10 @@ func BpSplit(bp client.BatchPoints, splitnum int) []client.BatchPoints {
 
 	points := bp.Points()
 	len := len(points)
-	lim := (len / splitnum) + 1
+	lim := (len + splitnum - 1) / splitnum
 	ret := make([]client.BatchPoints, 0, lim)
 
-	if len < splitnum {
+	if len <= splitnum {
 		ret = append(ret, bp)
 		return ret
 	}
@@ -513,12 +877,12 @@ func BpSplit(bp client.BatchPoints, splitnum int) []client.BatchPoints {
 
 		newbp, err := client.NewBatchPoints(bpcfg)
 		if err != nil {
-			log.Error("Error on create BatchPoints: %s", err)
+			log.Errorf("Error on create BatchPoints: %s", err)
 			return nil
 		}
-		pointchunk := make([]*client.Point, splitnum)
 		init := i * splitnum
 		end := min((i+1)*splitnum, len)
+		pointchunk := make([]*client.Point, end-init)
 		log.Debugf("Splitting %d batchpoints into %d  points chunks from %d to %d ", len, splitnum, init, end)
 		copy(pointchunk, points[init:end])
 		newbp.AddPoints(pointchunk)
@@ -528,7 +892,18 @@ func BpSplit(bp client.BatchPoints, splitnum int) []client.BatchPoints {
 	return ret
 }
 
-func WriteDB(c client.Client, bp client.BatchPoints) error {
+// bpBytes returns the size in line protocol of the bp points
+func bpBytes(bp client.BatchPoints) int64 {
+	var size int64
+	for _, p := range bp.Points() {
+		size += int64(len(p.String())) + 1
+	}
+	return size
+}
+
+// WriteDB writes bp in batches of max-points-on-single-write, on cancel of
+// ctx the batch being written finishes and the next ones are aborted
+func WriteDB(ctx context.Context, c client.Client, bp client.BatchPoints) error {
 
 	RWMaxRetries := MainConfig.General.RWMaxRetries
 	RWRetryDelay := MainConfig.General.RWRetryDelay
@@ -539,6 +914,17 @@ func WriteDB(c client.Client, bp client.BatchPoints) error {
 	sbp := BpSplit(bp, MaxPointsOnSingleWrite)
 
 	for k, b := range sbp {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := pointsLimit.wait(ctx, int64(len(b.Points()))); err != nil {
+			return err
+		}
+		if bytesLimit.getRate() > 0 {
+			if err := bytesLimit.wait(ctx, bpBytes(b)); err != nil {
+				return err
+			}
+		}
 		err := try.Do(func(attempt int) (bool, error) {
 			s := time.Now()
 			err := c.Write(b)
@@ -546,12 +932,16 @@ func WriteDB(c client.Client, bp client.BatchPoints) error {
 			log.Debugf("Write attempt [%d] took %s ", attempt, elapsed.String())
 			if err != nil {
 				log.Warnf("Fail to write batchpoints to write database error Trying again... in %s : Error %s  ", RWRetryDelay.String(), err)
-				time.Sleep(RWRetryDelay) // wait a minute
+				if serr := sleepCtx(ctx, RWRetryDelay); serr != nil {
+					return false, serr
+				}
 			}
 			return attempt < RWMaxRetries, err
 		})
 		if err != nil {
-			log.Errorf("Max Retries  ( %d ) exceeded on  write to database in write chunk %d, Last error: %s", RWMaxRetries, k, err)
+			if ctx.Err() == nil {
+				log.Errorf("Max Retries  ( %d ) exceeded on  write to database in write chunk %d, Last error: %s", RWMaxRetries, k, err)
+			}
 			return err
 		}
 	}
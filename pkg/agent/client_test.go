@@ -0,0 +1,30 @@
+package agent
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/influxdata/influxdb1-client/models"
+	"github.com/sirupsen/logrus"
+)
+
+func TestRowPointUnknownField(t *testing.T) {
+	SetLogger(logrus.New())
+	ser := models.Row{Name: "cpu", Columns: []string{"time", "usage", "newfield"}}
+	fieldmap := map[string]*FieldSch{"usage": {Name: "usage", Type: "float"}}
+	// newfield was created after the schema was read
+	p := rowPoint(ser, []interface{}{json.Number("1000000000"), json.Number("1.5"), json.Number("7")}, fieldmap)
+	if p == nil {
+		t.Fatalf("point not built")
+	}
+	fields, err := p.Fields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fields["newfield"]; ok {
+		t.Errorf("unknown field copied: %v", fields)
+	}
+	if fields["usage"] != 1.5 {
+		t.Errorf("usage is %v, want 1.5", fields["usage"])
+	}
+}
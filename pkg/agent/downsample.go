@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// downsampleFuncs are the aggregates allowed on downsampling, the true ones only apply to numeric fields
+var downsampleFuncs = map[string]bool{
+	"mean":   true,
+	"median": true,
+	"stddev": true,
+	"spread": true,
+	"sum":    true,
+	"count":  false,
+	"mode":   false,
+	"first":  false,
+	"last":   false,
+	"max":    true,
+	"min":    true,
+}
+
+// Downsample is the downsampling copy mode config, data is read with an aggregate per field
+// grouped by time(Interval) and written into the RP retention policy of the destination
+type Downsample struct {
+	Interval time.Duration
+	RP       string
+	// Aggregate is the default aggregate function
+	Aggregate string
+	// FieldAggregates are "field=function" pairs with the aggregate for some fields
+	FieldAggregates []string
+	fieldFuncs      map[string]string
+}
+
+// downsample is the downsampling config, nil if copies read raw data
+var downsample *Downsample
+
+// SetDownsample enables the downsampling copy mode, nil disables it
+func SetDownsample(ds *Downsample) error {
+	if ds == nil {
+		downsample = nil
+		return nil
+	}
+	if ds.Interval < time.Second || ds.Interval%time.Second != 0 {
+		return fmt.Errorf("invalid downsample interval %s, should be a whole number of seconds", ds.Interval)
+	}
+	if len(ds.RP) == 0 {
+		return fmt.Errorf("downsample retention policy is required")
+	}
+	if len(ds.Aggregate) == 0 {
+		ds.Aggregate = "mean"
+	}
+	if _, ok := downsampleFuncs[ds.Aggregate]; !ok {
+		return fmt.Errorf("invalid downsample aggregate %s", ds.Aggregate)
+	}
+	var err error
+	if ds.fieldFuncs, err = parsePairs(ds.FieldAggregates); err != nil {
+		return fmt.Errorf("downsample field aggregates: %s", err)
+	}
+	for f, fn := range ds.fieldFuncs {
+		if _, ok := downsampleFuncs[fn]; !ok {
+			return fmt.Errorf("invalid downsample aggregate %s for field %s", fn, f)
+		}
+	}
+	log.Infof("Downsampling copied data by %s with %s %v into RP %s", ds.Interval, ds.Aggregate, ds.FieldAggregates, ds.RP)
+	downsample = ds
+	return nil
+}
+
+// checkDownsample verifies the schema has only one source retention policy,
+// all the copied data is written into the single downsample RP. The RPs not
+// matching the rp filter are in the schema without measurements and not counted
+func checkDownsample(schema []*InfluxSchDb) error {
+	if downsample == nil {
+		return nil
+	}
+	for _, db := range schema {
+		names := []string{}
+		for _, rp := range db.Rps {
+			if rp.Measurements != nil {
+				names = append(names, rp.Name)
+			}
+		}
+		if len(names) > 1 {
+			return fmt.Errorf("DB %s has %d retention policies (%s), select only one with -rp", db.Name, len(names), strings.Join(names, ","))
+		}
+	}
+	return nil
+}
+
+// align returns t nanoseconds truncated to the start of its interval
+func (ds *Downsample) align(t int64) int64 {
+	iv := int64(ds.Interval)
+	r := t % iv
+	if r < 0 {
+		r += iv
+	}
+	return t - r
+}
+
+// alignDuration returns d rounded up to a multiple of the interval
+func (ds *Downsample) alignDuration(d time.Duration) time.Duration {
+	if r := d % ds.Interval; r != 0 {
+		d += ds.Interval - r
+	}
+	if d == 0 {
+		d = ds.Interval
+	}
+	return d
+}
+
+// fieldFunc returns the aggregate for field f and its result type, numeric
+// aggregates are replaced by last on non numeric fields
+func (ds *Downsample) fieldFunc(f *FieldSch) (string, string) {
+	fn, ok := ds.fieldFuncs[f.Name]
+	if !ok {
+		fn = ds.Aggregate
+	}
+	numeric := f.Type == "float" || f.Type == "integer" || f.Type == "unsigned"
+	if downsampleFuncs[fn] && !numeric {
+		fn = "last"
+	}
+	switch fn {
+	case "mean", "median", "stddev":
+		return fn, "float"
+	case "count":
+		return fn, "integer"
+	}
+	return fn, f.Type
+}
+
+// query returns the query to read the aggregates of measurement meas in the [start,end)
+// period for the fields and the fields of its results
+func (ds *Downsample) query(meas string, fields map[string]*FieldSch, start int64, end int64) (string, map[string]*FieldSch) {
+	names := make([]string, 0, len(fields))
+	for name, f := range fields {
+		if !f.Skip {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", nil
+	}
+	sort.Strings(names)
+	sel := make([]string, 0, len(names))
+	out := make(map[string]*FieldSch, len(names))
+	for _, name := range names {
+		fn, typ := ds.fieldFunc(fields[name])
+		sel = append(sel, fmt.Sprintf("%s(\"%s\") as \"%s\"", fn, name, name))
+		out[name] = &FieldSch{Name: name, Type: typ}
+	}
+	q := fmt.Sprintf("select %s from \"%s\" where time >= %d and time < %d%s group by time(%ds), * fill(none)",
+		strings.Join(sel, ","), meas, start, end, whereClause(), int64(ds.Interval.Seconds()))
+	return q, out
+}
@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDownsampleAlign(t *testing.T) {
+	ds := &Downsample{Interval: time.Hour}
+	h := int64(time.Hour)
+	tests := []struct {
+		t    int64
+		want int64
+	}{
+		{0, 0},
+		{h, h},
+		{h + 1, h},
+		{2*h - 1, h},
+		{-1, -h},
+		{-h, -h},
+	}
+	for _, tt := range tests {
+		if got := ds.align(tt.t); got != tt.want {
+			t.Errorf("align(%d) = %d, want %d", tt.t, got, tt.want)
+		}
+	}
+	if d := ds.alignDuration(90 * time.Minute); d != 2*time.Hour {
+		t.Errorf("alignDuration(90m) = %s, want 2h", d)
+	}
+	if d := ds.alignDuration(0); d != time.Hour {
+		t.Errorf("alignDuration(0) = %s, want 1h", d)
+	}
+}
+
+func TestCheckDownsample(t *testing.T) {
+	defer func() { downsample = nil }()
+	downsample = &Downsample{Interval: time.Hour, RP: "rollup_1y"}
+	meas := map[string]*MeasurementSch{"cpu": {Name: "cpu", NewName: "cpu"}}
+	// rollup_1y does not match the rp filter and has no measurements
+	schema := []*InfluxSchDb{{Name: "telegraf", Rps: []*RetPol{
+		{Name: "autogen", Def: true, Measurements: meas},
+		{Name: "rollup_1y"},
+	}}}
+	if err := checkDownsample(schema); err != nil {
+		t.Errorf("filtered RP counted: %s", err)
+	}
+	schema[0].Rps[1].Measurements = map[string]*MeasurementSch{}
+	if err := checkDownsample(schema); err == nil {
+		t.Errorf("two selected RPs accepted")
+	}
+	downsample = nil
+	if err := checkDownsample(schema); err != nil {
+		t.Errorf("checked without downsampling: %s", err)
+	}
+}
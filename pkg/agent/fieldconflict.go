@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/v2"
+)
+
+// Field type conflict policies, applied to the fields whose type differs between shards
+const (
+	// FieldConflictPreserve copies each shard group with the field types it has on the source
+	FieldConflictPreserve = "preserve"
+	// FieldConflictCoerce converts all the values to float if all the types are numeric or to string if not
+	FieldConflictCoerce = "coerce"
+	// FieldConflictSkip does not copy the conflicting fields
+	FieldConflictSkip = "skip"
+)
+
+// coerceType returns the type all the values of types can be converted to
+func coerceType(types []string) string {
+	for _, t := range types {
+		switch t {
+		case "float", "integer", "unsigned":
+		default:
+			return "string"
+		}
+	}
+	return "float"
+}
+
+// resolveFieldConflicts reports the fields of measurement m whose type differs between shards
+// and prepares them to be copied with the field-type-conflict policy. Conflicting measurements
+// are read by shard group, so each query returns the values of only one field type.
+func resolveFieldConflicts(c client.Client, sdb string, rp string, m *MeasurementSch) {
+	conflicts := []string{}
+	for _, f := range m.Fields {
+		if len(f.Types) > 0 {
+			conflicts = append(conflicts, f.Name)
+		}
+	}
+	if len(conflicts) == 0 {
+		return
+	}
+	sort.Strings(conflicts)
+
+	policy := MainConfig.General.FieldTypeConflict
+	desc := make([]string, 0, len(conflicts))
+	for _, name := range conflicts {
+		f := m.Fields[name]
+		switch policy {
+		case FieldConflictSkip:
+			f.Skip = true
+			desc = append(desc, fmt.Sprintf("%s [%s] skipped", name, strings.Join(f.Types, ",")))
+		default:
+			// also used on preserve for periods not in any shard group
+			f.Type = coerceType(f.Types)
+			desc = append(desc, fmt.Sprintf("%s [%s] as %s", name, strings.Join(f.Types, ","), f.Type))
+		}
+	}
+	log.Warnf("Field type conflict on DB %s RP %s Measurement %s (%s policy): %s", sdb, rp, m.Name, policy, strings.Join(desc, " | "))
+
+	if policy == FieldConflictSkip {
+		return
+	}
+	groups, err := GetShardGroups(c, sdb, rp)
+	if err != nil {
+		log.Errorf("Error on get shard groups for DB %s RP %s, measurement %s will not be read by shard group: %s", sdb, rp, m.Name, err)
+		return
+	}
+	shards := make([]*ShardFieldSch, 0, len(groups))
+	for _, g := range groups {
+		sf := &ShardFieldSch{Start: g[0], End: g[1], Fields: m.Fields}
+		if policy == FieldConflictPreserve {
+			types, err := GetFieldTypesByTime(c, sdb, rp, m.Name, time.Unix(0, g[0]), time.Unix(0, g[1]))
+			if err != nil {
+				log.Errorf("Error on get field types for DB %s RP %s Measurement %s from %d to %d, coercing them: %s", sdb, rp, m.Name, g[0], g[1], err)
+			} else {
+				sf.Fields = make(map[string]*FieldSch, len(m.Fields))
+				for name, f := range m.Fields {
+					if t, ok := types[name]; ok && len(f.Types) > 0 {
+						sf.Fields[name] = &FieldSch{Name: name, Type: t}
+						continue
+					}
+					sf.Fields[name] = f
+				}
+			}
+		}
+		shards = append(shards, sf)
+	}
+	m.Shards = shards
+}
+
+// fieldRange is a [start,end) nanosecond period to read with the fields types in Fields
+type fieldRange struct {
+	start  int64
+	end    int64
+	fields map[string]*FieldSch
+}
+
+// fieldRanges splits the [start,end) period at the shard group limits if the measurement
+// has field type conflicts, returning the fields to read each part with
+func (m *MeasurementSch) fieldRanges(start int64, end int64) []fieldRange {
+	if len(m.Shards) == 0 {
+		return []fieldRange{{start, end, m.Fields}}
+	}
+	ranges := []fieldRange{}
+	cur := start
+	for _, sf := range m.Shards {
+		if sf.End <= cur || sf.Start >= end {
+			continue
+		}
+		if sf.Start > cur {
+			ranges = append(ranges, fieldRange{cur, sf.Start, m.Fields})
+			cur = sf.Start
+		}
+		e := sf.End
+		if e > end {
+			e = end
+		}
+		ranges = append(ranges, fieldRange{cur, e, sf.Fields})
+		cur = e
+	}
+	if cur < end {
+		ranges = append(ranges, fieldRange{cur, end, m.Fields})
+	}
+	return ranges
+}
@@ -1,8 +1,12 @@
 package agent
 
 import (
+	"context"
+	"fmt"
 	"regexp"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,33 +18,179 @@ type InfluxSchDb struct {
 	Rps      []*RetPol
 }
 
+// dstRP returns the retention policy where the data of rp is written, the new default
+// one if rp is the default or the downsample one on the downsampling copy mode
+func (db *InfluxSchDb) dstRP(rp *RetPol) *RetPol {
+	rn := *rp
+	if rp.Def {
+		rn.Name = db.NewDefRp
+	}
+	if downsample != nil {
+		rn.Name = downsample.RP
+	}
+	return &rn
+}
+
 type MeasurementSch struct {
-	Name   string
-	Fields map[string]*FieldSch
+	Name string
+	// NewName is the measurement name on the destination after the transform rules
+	NewName string
+	Fields  map[string]*FieldSch
+	// Shards are the fields of each shard group time range, only set if some
+	// field type differs between shards, data is read by shard group then
+	Shards []*ShardFieldSch
 }
 
 type FieldSch struct {
 	Name string
 	Type string
+	// Types are all the field types found if they differ between shards
+	Types []string
+	// Skip fields are not copied
+	Skip bool
+}
+
+// ShardFieldSch are the fields of a measurement in the [Start,End) nanosecond
+// period of a shard group
+type ShardFieldSch struct {
+	Start  int64
+	End    int64
+	Fields map[string]*FieldSch
+}
+
+// HA Cluster states
+const (
+	ClusterStateOK               = "OK"
+	ClusterStateSlaveDown        = "CHECK_SLAVE_DOWN"
+	ClusterStateRecovering       = "RECOVERING"
+	ClusterStateMasterDown       = "CHECK_MASTER_DOWN"
+	ClusterStateRecoveringMaster = "RECOVERING_MASTER"
+	ClusterStateAllDown          = "ALL_DOWN"
+	ClusterStateDegraded         = "DEGRADED"
+	ClusterStateMaintenance      = "MAINTENANCE"
+)
+
+// OutageWindow is the period of time in which a node could have lost data
+type OutageWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// HANode tracks the health state and the pending outage windows of a cluster node
+type HANode struct {
+	Monitor       *InfluxMonitor
+	StateOK       bool
+	LastOK        time.Time
+	CheckDuration time.Duration
+	// Outages are the windows pending to recover, the last one could be still open (zero End)
+	Outages []*OutageWindow
+	// Recovering are the windows being recovered by a background job
+	Recovering []*OutageWindow
+	// Mismatches are the differences found on the last recovery verification
+	Mismatches []*DataMismatch
+	// Maintenance is true while the node is in a planned downtime
+	Maintenance bool
+}
+
+// ID returns the configured name of the node
+func (n *HANode) ID() string {
+	return n.Monitor.cfg.Name
+}
+
+// complete returns true if node is healthy and has not lost data
+func (n *HANode) complete() bool {
+	return n.StateOK && !n.Maintenance && len(n.Outages) == 0 && len(n.Recovering) == 0
+}
+
+// openOutage begins a new outage window on the node
+func (n *HANode) openOutage(start time.Time) {
+	n.Outages = append(n.Outages, &OutageWindow{Start: start})
+}
+
+// closeOutage ends the current open outage window on the node
+func (n *HANode) closeOutage(end time.Time) {
+	if l := len(n.Outages); l > 0 && n.Outages[l-1].End.IsZero() {
+		n.Outages[l-1].End = end
+	}
+}
+
+// takeOutages removes from the node all the closed outage windows and returns them merged
+// (windows separated less than gap are also merged)
+func (n *HANode) takeOutages(gap time.Duration) []*OutageWindow {
+	closed := n.Outages
+	n.Outages = nil
+	if l := len(closed); l > 0 && closed[l-1].End.IsZero() {
+		n.Outages = closed[l-1:]
+		closed = closed[:l-1]
+	}
+	return mergeOutages(closed, gap)
+}
+
+// mergeOutages returns a copy of the windows sorted by start, merging the overlapped ones
+// and the adjacent ones separated less than gap
+func mergeOutages(windows []*OutageWindow, gap time.Duration) []*OutageWindow {
+	sorted := make([]*OutageWindow, 0, len(windows))
+	for _, ow := range windows {
+		c := *ow
+		sorted = append(sorted, &c)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+
+	merged := []*OutageWindow{}
+	for _, ow := range sorted {
+		if l := len(merged); l > 0 && !ow.Start.After(merged[l-1].End.Add(gap)) {
+			if ow.End.After(merged[l-1].End) {
+				merged[l-1].End = ow.End
+			}
+			continue
+		}
+		merged = append(merged, ow)
+	}
+	return merged
 }
 
 type HACluster struct {
-	Master                     *InfluxMonitor
-	Slave                      *InfluxMonitor
-	CheckInterval              time.Duration
+	// Master is the designated source for data recovery
+	Master *InfluxMonitor
+	// Slave is the first of the slave nodes, the destination on copy actions
+	Slave *InfluxMonitor
+	// Nodes are all the replica set nodes, master is always the first one
+	Nodes         []*HANode
+	CheckInterval time.Duration
+	// RecoveryPrePadding and RecoveryPostPadding enlarge the outage windows
+	// to recover data written near the detected down/up times
+	RecoveryPrePadding  time.Duration
+	RecoveryPostPadding time.Duration
+	// RecoveryMaxRetries is the number of times a window is recovered again if verification fails
+	RecoveryMaxRetries         int
+	SyncMode                   string
 	ClusterState               string
-	SlaveStateOK               bool
-	SlaveLastOK                time.Time
-	SlaveCheckDuration         time.Duration
-	MasterStateOK              bool
-	MasterLastOK               time.Time
-	MasterCheckDuration        time.Duration
 	ClusterNumRecovers         int
 	ClusterLastRecoverDuration time.Duration
 	statsData                  sync.RWMutex
+	recoverWg                  sync.WaitGroup
 	Schema                     []*InfluxSchDb
 	ChunkDuration              time.Duration
 	MaxRetentionInterval       time.Duration
+	// DataDir is where the cluster state is persisted
+	DataDir string
+	// RetryQueue keeps the chunks that failed recovery (only on hamonitor)
+	RetryQueue *RetryQueue
+	// Checkpoint keeps the progress to resume copies (only on copy actions)
+	Checkpoint *Checkpoint
+}
+
+// NodeStatus is the health status of a cluster node
+type NodeStatus struct {
+	ID          string
+	Master      bool
+	State       bool
+	LastOK      time.Time
+	Maintenance bool
+	ClockSkew   time.Duration
+	Recovering  []OutageWindow
+	Outages     []OutageWindow
+	Mismatches  []DataMismatch
 }
 
 type ClusterStatus struct {
@@ -51,28 +201,94 @@ type ClusterStatus struct {
 	SID                        string
 	MasterState                bool
 	MasterLastOK               time.Time
-	SlaveState                 bool
-	SlaveLastOK                time.Time
+	// SlaveState and SlaveLastOK are the ones of the first slave node
+	SlaveState  bool
+	SlaveLastOK time.Time
+	Nodes       []*NodeStatus
+}
+
+func (n *HANode) status(master bool) *NodeStatus {
+	ns := &NodeStatus{
+		ID:          n.ID(),
+		Master:      master,
+		State:       n.StateOK,
+		LastOK:      n.LastOK,
+		Maintenance: n.Maintenance,
+		ClockSkew:   n.Monitor.GetClockSkew(),
+		Recovering:  []OutageWindow{},
+		Outages:     []OutageWindow{},
+		Mismatches:  []DataMismatch{},
+	}
+	for _, ow := range n.Recovering {
+		ns.Recovering = append(ns.Recovering, *ow)
+	}
+	for _, ow := range n.Outages {
+		ns.Outages = append(ns.Outages, *ow)
+	}
+	for _, dm := range n.Mismatches {
+		ns.Mismatches = append(ns.Mismatches, *dm)
+	}
+	return ns
 }
 
 func (hac *HACluster) GetStatus() *ClusterStatus {
 	hac.statsData.RLock()
 	defer hac.statsData.RUnlock()
-	return &ClusterStatus{
+	master := hac.Nodes[0]
+	status := &ClusterStatus{
 		ClusterState:               hac.ClusterState,
-		MID:                        hac.Master.cfg.Name,
-		SID:                        hac.Slave.cfg.Name,
+		MID:                        master.ID(),
 		ClusterNumRecovers:         hac.ClusterNumRecovers,
 		ClusterLastRecoverDuration: hac.ClusterLastRecoverDuration,
-		MasterState:                hac.MasterStateOK,
-		MasterLastOK:               hac.MasterLastOK,
-		SlaveState:                 hac.SlaveStateOK,
-		SlaveLastOK:                hac.SlaveLastOK,
+		MasterState:                master.StateOK,
+		MasterLastOK:               master.LastOK,
+	}
+	if len(hac.Nodes) > 1 {
+		slave := hac.Nodes[1]
+		status.SID = slave.ID()
+		status.SlaveState = slave.StateOK
+		status.SlaveLastOK = slave.LastOK
+	}
+	for k, n := range hac.Nodes {
+		status.Nodes = append(status.Nodes, n.status(k == 0))
+	}
+	return status
+}
+
+// node returns the cluster node with the id name
+func (hac *HACluster) node(id string) *HANode {
+	for _, n := range hac.Nodes {
+		if n.ID() == id {
+			return n
+		}
+	}
+	return nil
+}
+
+// GetNodeStatus returns the health status of the node with the id name
+func (hac *HACluster) GetNodeStatus(id string) *NodeStatus {
+	hac.statsData.RLock()
+	defer hac.statsData.RUnlock()
+	for k, n := range hac.Nodes {
+		if n.ID() == id {
+			return n.status(k == 0)
+		}
 	}
+	return nil
 }
 
 // From Master to Slave
 func (hac *HACluster) GetSchema(dbfilter string, rpfilter string, measfilter string) ([]*InfluxSchDb, error) {
+	schema, err := getSchema(hac.Master, dbfilter, rpfilter, measfilter)
+	if err != nil {
+		return nil, err
+	}
+	hac.Schema = schema
+	return schema, nil
+}
+
+// getSchema discovers databases, retention policies and measurements on the src node
+func getSchema(src *InfluxMonitor, dbfilter string, rpfilter string, measfilter string) ([]*InfluxSchDb, error) {
 
 	schema := []*InfluxSchDb{}
 
@@ -89,7 +305,7 @@ func (hac *HACluster) GetSchema(dbfilter string, rpfilter string, measfilter str
 		}
 	}
 
-	srcDBs, _ := GetDataBases(hac.Master.cli)
+	srcDBs, _ := GetDataBases(src.cli)
 
 	for _, db := range srcDBs {
 
@@ -99,9 +315,9 @@ func (hac *HACluster) GetSchema(dbfilter string, rpfilter string, measfilter str
 		}
 
 		// Get Retention policies
-		rps, err := GetRetentionPolicies(hac.Master.cli, db)
+		rps, err := GetRetentionPolicies(src.cli, db)
 		if err != nil {
-			log.Errorf("Error on get Retention Policies on Database %s MasterDB %s : Error: %s", db, hac.Master.cfg.Name, err)
+			log.Errorf("Error on get Retention Policies on Database %s DB %s : Error: %s", db, src.cfg.Name, err)
 			continue
 		}
 
@@ -124,7 +340,7 @@ func (hac *HACluster) GetSchema(dbfilter string, rpfilter string, measfilter str
 				defaultRp = rp
 			}
 
-			meas := GetMeasurements(hac.Master.cli, db, rp.Name, measfilter)
+			meas := GetMeasurements(src.cli, db, rp.Name, measfilter)
 
 			if len(measfilter) > 0 {
 				filtermeas, err = regexp.Compile(measfilter)
@@ -142,9 +358,10 @@ func (hac *HACluster) GetSchema(dbfilter string, rpfilter string, measfilter str
 					continue
 				}
 
-				log.Debugf("discovered measurement  %s on DB: %s-RP:%s", m, db, rp.Name)
+				log.Debugf("discovered measurement  %s on DB: %s-RP:%s", m.Name, db, rp.Name)
 				mf[m.Name] = m
-				mf[m.Name].Fields = GetFields(hac.Master.cli, db, m.Name, rp.Name)
+				mf[m.Name].Fields = GetFields(src.cli, db, m.Name, rp.Name)
+				resolveFieldConflicts(src.cli, db, rp.Name, mf[m.Name])
 			}
 			rp.Measurements = mf
 
@@ -152,17 +369,21 @@ func (hac *HACluster) GetSchema(dbfilter string, rpfilter string, measfilter str
 
 		// Check if default RP is valid
 		if defaultRp == nil {
-			log.Errorf("Error on Create DB  %s on SlaveDB %s : Database has not default Retention Policy ", db, hac.Slave.cfg.Name)
+			log.Errorf("Error on Database %s on DB %s : Database has not default Retention Policy ", db, src.cfg.Name)
 			continue
 		}
 		schema = append(schema, &InfluxSchDb{Name: db, NewName: db, DefRp: defaultRp.Name, NewDefRp: defaultRp.Name, Rps: rps})
 	}
-	hac.Schema = schema
 	return schema, nil
 }
 
 // From Master to Slave
 func (hac *HACluster) ReplicateSchema(schema []*InfluxSchDb) error {
+	return replicateSchema(hac.Slave, schema)
+}
+
+// replicateSchema creates all databases and retention policies from schema on dst
+func replicateSchema(dst *InfluxMonitor, schema []*InfluxSchDb) error {
 
 	for _, db := range schema {
 		//check for default RP
@@ -176,10 +397,10 @@ func (hac *HACluster) ReplicateSchema(schema []*InfluxSchDb) error {
 			}
 		}
 
-		crdberr := CreateDB(hac.Slave.cli, db.NewName, &defaultRp)
+		crdberr := CreateDB(dst.cli, db.NewName, &defaultRp)
 
 		if crdberr != nil {
-			log.Errorf("Error on Create DB  %s on SlaveDB %s : Error: %s", db, hac.Slave.cfg.Name, crdberr)
+			log.Errorf("Error on Create DB  %s on DB %s : Error: %s", db.NewName, dst.cfg.Name, crdberr)
 			//continue
 		}
 		for _, rp := range db.Rps {
@@ -187,74 +408,162 @@ func (hac *HACluster) ReplicateSchema(schema []*InfluxSchDb) error {
 			if rp.Def {
 				// default has been previously created
 				// Ensure its default
-				crrperr := CreateRP(hac.Slave.cli, db.NewName, &defaultRp)
+				crrperr := CreateRP(dst.cli, db.NewName, &defaultRp)
 				if crrperr != nil {
-					log.Errorf("Error on Create Retention Policies on Database %s SlaveDB %s : Error: %s", db, hac.Slave.cfg.Name, crrperr)
+					log.Errorf("Error on Create Retention Policies on Database %s DB %s : Error: %s", db.NewName, dst.cfg.Name, crrperr)
 				}
-				alrperr := SetDefaultRP(hac.Slave.cli, db.NewName, &defaultRp)
+				alrperr := SetDefaultRP(dst.cli, db.NewName, &defaultRp)
 				if alrperr != nil {
-					log.Errorf("Error on Altern Retention Policies on Database %s SlaveDB %s : Error: %s", db, hac.Slave.cfg.Name, alrperr)
+					log.Errorf("Error on Altern Retention Policies on Database %s DB %s : Error: %s", db.NewName, dst.cfg.Name, alrperr)
 				}
 				continue
 			}
 			//For other cases, creates the RP
-			log.Infof("Creating Extra Retention Policy %s on database %s ", rp.Name, db)
-			crrperr := CreateRP(hac.Slave.cli, db.NewName, rp)
+			log.Infof("Creating Extra Retention Policy %s on database %s ", rp.Name, db.NewName)
+			crrperr := CreateRP(dst.cli, db.NewName, rp)
 			if crrperr != nil {
-				log.Errorf("Error on Create Retention Policies on Database %s SlaveDB %s : Error: %s", db, hac.Slave.cfg.Name, crrperr)
+				log.Errorf("Error on Create Retention Policies on Database %s DB %s : Error: %s", db.NewName, dst.cfg.Name, crrperr)
 				continue
 			}
-			log.Infof("Replication Schema: DB %s OK", db)
+			log.Infof("Replication Schema: DB %s OK", db.NewName)
+			for _, m := range rp.Measurements {
+				if m.NewName != m.Name {
+					log.Infof("Replication Schema: measurement %s will be copied as %s on DB %s RP %s", m.Name, m.NewName, db.NewName, rp.Name)
+				}
+			}
 		}
 	}
 	return nil
 }
 
-func (hac *HACluster) ReplicateData(schema []*InfluxSchDb, start time.Time, end time.Time) error {
+// From Master to Slave
+func (hac *HACluster) ReplicateData(ctx context.Context, schema []*InfluxSchDb, start time.Time, end time.Time) error {
+	return hac.replicateData(ctx, hac.Master, hac.Slave, schema, start, end)
+}
+
+// From Master to Slave
+func (hac *HACluster) ReplicateDataFull(ctx context.Context, schema []*InfluxSchDb) error {
+	return hac.replicateDataFull(ctx, hac.Master, hac.Slave, schema)
+}
+
+// replicateDataFull copies data for all the retention period of the schema from src to dst
+func (hac *HACluster) replicateDataFull(ctx context.Context, src *InfluxMonitor, dst *InfluxMonitor, schema []*InfluxSchDb) error {
+	// each db/rp runs concurrently, data is copied by the shared scheduler
+	var wg sync.WaitGroup
+	var failed int32
 	for _, db := range schema {
 		for _, rp := range db.Rps {
-			log.Infof("Replicating Data from DB %s RP %s...", db.Name, rp.Name)
-			//Need to check if the rp is the default, in that case must provide other name
-			rn := *rp
-			if rp.Def {
-				rn.Name = db.NewDefRp
-			}
-			//log.Debugf("%s RP %s... SCHEMA %#+v.", db.Name, rp.Name, db)
-			report := SyncDBRP(hac.Master, hac.Slave, db.Name, db.NewName, rp, &rn, start, end, db, hac.ChunkDuration, hac.MaxRetentionInterval)
-			if report == nil {
-				log.Errorf("Data Replication error in DB [%s] RP [%s] ", db, rn.Name)
-			}
-			if len(report.BadChunks) > 0 {
-				r, w, t := report.RWErrors()
-				log.Errorf("Data Replication error in DB [%s] RP [%s] | Registered %d Read %d Write | %d Total Errors", db, r, w, t)
-			}
+			db, rp := db, rp
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				log.Infof("Replicating Data from DB %s RP %s [%s -> %s]....", db.Name, rp.Name, src.cfg.Name, dst.cfg.Name)
+				start, end := rp.GetFirstLastTime(hac.MaxRetentionInterval)
+				if hac.Checkpoint != nil {
+					start, end = hac.Checkpoint.Range(db.Name, rp.Name, start, end)
+				}
+				rn := db.dstRP(rp)
+				report := SyncDBRP(ctx, src, dst, db.Name, db.NewName, rp, rn, start, end, db, hac.ChunkDuration, hac.MaxRetentionInterval, hac.Checkpoint)
+				if report == nil {
+					log.Errorf("Data Replication error in DB [%s] RP [%s] ", db.Name, rn.Name)
+					atomic.AddInt32(&failed, 1)
+					return
+				}
+				if len(report.BadChunks) > 0 || report.Cancelled {
+					atomic.AddInt32(&failed, 1)
+				}
+				if len(report.BadChunks) > 0 {
+					r, w, t := report.RWErrors()
+					log.Errorf("Data Replication error in DB [%s] RP [%s] | Registered %d Read %d Write | %d Total Errors", db.Name, rn.Name, r, w, t)
+				}
+			}()
 		}
 	}
+	wg.Wait()
+	if failed > 0 {
+		return fmt.Errorf("%d db/rp not fully replicated", failed)
+	}
 	return nil
 }
 
-func (hac *HACluster) ReplicateDataFull(schema []*InfluxSchDb) error {
+// replicateData copies data in the [start,end] period for all the schema from src to dst
+func (hac *HACluster) replicateData(ctx context.Context, src *InfluxMonitor, dst *InfluxMonitor, schema []*InfluxSchDb, start time.Time, end time.Time) error {
+	// each db/rp runs concurrently, data is copied by the shared scheduler
+	var wg sync.WaitGroup
+	var failed int32
 	for _, db := range schema {
 		for _, rp := range db.Rps {
-			log.Infof("Replicating Data from DB %s RP %s....", db.Name, rp.Name)
-			start, end := rp.GetFirstLastTime(hac.MaxRetentionInterval)
-			rn := *rp
-			if rn.Def {
-				rn.Name = db.NewDefRp
-			}
-			report := SyncDBRP(hac.Master, hac.Slave, db.Name, db.NewName, rp, &rn, start, end, db, hac.ChunkDuration, hac.MaxRetentionInterval)
-			if report == nil {
-				log.Errorf("Data Replication error in DB [%s] RP [%s] ", db, rn.Name)
-			}
-			if len(report.BadChunks) > 0 {
-				r, w, t := report.RWErrors()
-				log.Errorf("Data Replication error in DB [%s] RP [%s] | Registered %d Read %d Write | %d Total Errors", db, r, w, t)
-			}
+			db, rp := db, rp
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				log.Infof("Replicating Data from DB %s RP %s [%s -> %s]...", db.Name, rp.Name, src.cfg.Name, dst.cfg.Name)
+				//Need to check if the rp is the default, in that case must provide other name
+				rn := db.dstRP(rp)
+				//log.Debugf("%s RP %s... SCHEMA %#+v.", db.Name, rp.Name, db)
+				s, e := start, end
+				if hac.Checkpoint != nil {
+					s, e = hac.Checkpoint.Range(db.Name, rp.Name, start, end)
+				}
+				report := SyncDBRP(ctx, src, dst, db.Name, db.NewName, rp, rn, s, e, db, hac.ChunkDuration, hac.MaxRetentionInterval, hac.Checkpoint)
+				if report == nil {
+					log.Errorf("Data Replication error in DB [%s] RP [%s] ", db.Name, rn.Name)
+					atomic.AddInt32(&failed, 1)
+					return
+				}
+				if len(report.BadChunks) > 0 || report.Cancelled {
+					atomic.AddInt32(&failed, 1)
+				}
+				if len(report.BadChunks) > 0 {
+					r, w, t := report.RWErrors()
+					log.Errorf("Data Replication error in DB [%s] RP [%s] | Registered %d Read %d Write | %d Total Errors", db.Name, rn.Name, r, w, t)
+					if hac.RetryQueue != nil && !report.Cancelled {
+						hac.RetryQueue.queueBadChunks(report)
+					}
+				}
+			}()
 		}
 	}
+	wg.Wait()
+	if failed > 0 {
+		return fmt.Errorf("%d db/rp not fully replicated", failed)
+	}
 	return nil
 }
 
+// recoverNode copies the data lost by dst from src in the [start,end] period, verifies
+// the copied data and returns the time taken by the recovery and the found mismatches
+func (hac *HACluster) recoverNode(ctx context.Context, src *InfluxMonitor, dst *InfluxMonitor, start time.Time, end time.Time) (time.Duration, []*DataMismatch) {
+	// after conection recover with de database the
+	// the client should be updated before any connection test
+	dst.UpdateCli()
+	// begin recover
+	log.Infof("HACLUSTER: INIT RECOVERY [%s -> %s] : FROM [ %s ] TO [ %s ]", src.cfg.Name, dst.cfg.Name, start.String(), end.String())
+	s := time.Now()
+	//refresh schema from the node which has the data
+	log.Infof("HACLUSTER: INIT REFRESH SCHEMA")
+	schema, err := getSchema(src, "", "", "")
+	if err != nil {
+		log.Errorf("HACLUSTER: error on refresh schema from %s : %s", src.cfg.Name, err)
+	}
+	if src == hac.Master {
+		hac.statsData.Lock()
+		hac.Schema = schema
+		hac.statsData.Unlock()
+	}
+	log.Infof("HACLUSTER: INIT REPLICATION DATA PROCESS")
+	hac.replicateData(ctx, src, dst, schema, start, end)
+	if ctx.Err() != nil {
+		log.Warnf("HACLUSTER: RECOVERY [%s -> %s] CANCELLED", src.cfg.Name, dst.cfg.Name)
+		return time.Since(s), nil
+	}
+	log.Infof("HACLUSTER: INIT VERIFICATION DATA PROCESS")
+	mismatches := verifyData(src, dst, schema, start, end)
+	elapsed := time.Since(s)
+	log.Infof("HACLUSTER: DATA SYNCRONIZATION Took %s (%d mismatches)", elapsed.String(), len(mismatches))
+	return elapsed, mismatches
+}
+
 // ScltartMonitor Main GoRutine method to begin snmp data collecting
 func (hac *HACluster) SuperVisor(wg *sync.WaitGroup) {
 	wg.Add(1)
@@ -262,119 +571,289 @@ func (hac *HACluster) SuperVisor(wg *sync.WaitGroup) {
 }
 
 // OK -> CHECK_SLAVE_DOWN -> RECOVERING -> OK
+// OK -> CHECK_MASTER_DOWN -> RECOVERING_MASTER -> OK
+// OK -> CHECK_*_DOWN -> ALL_DOWN -> CHECK_*_DOWN -> RECOVERING* -> OK
+
+// source returns the node from where lost data will be recovered: the master
+// if healthy, if not the first healthy node without pending outages.
+// hac.statsData should be locked by the caller
+func (hac *HACluster) source() *HANode {
+	for _, n := range hac.Nodes {
+		if n.complete() {
+			return n
+		}
+	}
+	// no complete node: take the first healthy one
+	for _, n := range hac.Nodes {
+		if n.StateOK && !n.Maintenance {
+			return n
+		}
+	}
+	return nil
+}
 
-func (hac *HACluster) checkCluster() {
+// dropMasterOutages discards the closed master outage windows, they are not recovered on onlyslave sync-mode.
+// hac.statsData should be locked by the caller
+func (hac *HACluster) dropMasterOutages(n *HANode) {
+	if n != hac.Nodes[0] || hac.SyncMode == "twoway" {
+		return
+	}
+	for _, ow := range n.takeOutages(hac.CheckInterval) {
+		log.Warnf("HACLUSTER: MASTER %s lost data FROM [ %s ] TO [ %s ] will not be recovered on sync-mode %s", n.ID(), ow.Start.String(), ow.End.String(), hac.SyncMode)
+	}
+}
+
+// currentState computes the cluster state from the node health and the running recoveries.
+// hac.statsData should be locked by the caller
+func (hac *HACluster) currentState() string {
+	numDown := 0
+	numRecovering := 0
+	numMaintenance := 0
+	for _, n := range hac.Nodes {
+		if n.Maintenance {
+			numMaintenance++
+			continue
+		}
+		if !n.StateOK {
+			numDown++
+		}
+		if len(n.Recovering) > 0 {
+			numRecovering++
+		}
+	}
+	switch {
+	case numDown+numMaintenance == len(hac.Nodes):
+		return ClusterStateAllDown
+	case !hac.Nodes[0].StateOK:
+		return ClusterStateMasterDown
+	case numDown > 0:
+		return ClusterStateSlaveDown
+	case len(hac.Nodes[0].Recovering) > 0:
+		return ClusterStateRecoveringMaster
+	case numRecovering > 0:
+		return ClusterStateRecovering
+	case numMaintenance > 0:
+		return ClusterStateMaintenance
+	}
+	for _, n := range hac.Nodes {
+		if len(n.Mismatches) > 0 {
+			return ClusterStateDegraded
+		}
+	}
+	return ClusterStateOK
+}
+
+func maxAbsDuration(a, b time.Duration) time.Duration {
+	if a < 0 {
+		a = -a
+	}
+	if b < 0 {
+		b = -b
+	}
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// recoverJob copies in background from src to dst the data lost in the dst recovering windows
+func (hac *HACluster) recoverJob(src *HANode, dst *HANode) {
+	defer hac.recoverWg.Done()
+	ctx, done := startJob(fmt.Sprintf("recovery %s -> %s", src.ID(), dst.ID()))
+	defer done()
+
+	hac.statsData.RLock()
+	windows := dst.Recovering
+	hac.statsData.RUnlock()
+
+	// enlarge windows with the clock skew between nodes and syncflux
+	// to avoid missing points timestamped by the database servers
+	skew := maxAbsDuration(src.Monitor.GetClockSkew(), dst.Monitor.GetClockSkew())
+	if skew > 0 {
+		log.Infof("HACLUSTER: recovery windows on %s enlarged %s by clock skew", dst.ID(), skew.String())
+	}
+
+	var elapsed time.Duration
+	mismatches := []*DataMismatch{}
+	retry := []*OutageWindow{}
+	for k, ow := range windows {
+		if ctx.Err() != nil {
+			// cancelled windows are recovered again later
+			log.Warnf("HACLUSTER: recovery on %s cancelled, %d windows pending", dst.ID(), len(windows)-k)
+			retry = append(retry, windows[k:]...)
+			break
+		}
+		e, dm := hac.recoverNode(ctx, src.Monitor, dst.Monitor, ow.Start.Add(-skew), ow.End.Add(skew))
+		elapsed += e
+		if ctx.Err() != nil {
+			retry = append(retry, ow)
+			continue
+		}
+		// only the mismatched measurements are copied again
+		failed := []*DataMismatch{}
+		for _, m := range dm {
+			if !hac.recoverMismatch(ctx, src, dst, m) {
+				failed = append(failed, m)
+			}
+		}
+		if ctx.Err() != nil {
+			retry = append(retry, ow)
+			continue
+		}
+		mismatches = append(mismatches, failed...)
+	}
+
+	hac.statsData.Lock()
+	dst.Recovering = nil
+	if hac.RetryQueue != nil {
+		// queued mismatches are removed when their retry is verified
+		dst.Mismatches = append(dst.Mismatches, mismatches...)
+	} else {
+		dst.Mismatches = mismatches
+	}
+	// failed windows will be scheduled again on next check
+	dst.Outages = append(retry, dst.Outages...)
+	hac.ClusterNumRecovers++
+	hac.ClusterLastRecoverDuration = elapsed
+	hac.ClusterState = hac.currentState()
+	hac.statsData.Unlock()
+
+	if err := hac.SaveState(); err != nil {
+		log.Errorf("HACLUSTER: error on save cluster state: %s", err)
+	}
+}
+
+// recoverMismatch copies again from src to dst the measurement which failed verification up to
+// RecoveryMaxRetries times, if it still differs it is queued on the retry queue and false is returned
+func (hac *HACluster) recoverMismatch(ctx context.Context, src *HANode, dst *HANode, dm *DataMismatch) bool {
+	ri := &RetryItem{
+		Src:         src.ID(),
+		Dst:         dst.ID(),
+		SrcDB:       dm.DB,
+		DstDB:       dm.DB,
+		SrcRP:       dm.RP,
+		DstRP:       dm.RP,
+		Measurement: dm.Measurement,
+		Start:       dm.Start,
+		End:         dm.End,
+		Verify:      true,
+	}
+	for i := 1; i <= hac.RecoveryMaxRetries; i++ {
+		log.Warnf("HACLUSTER: recovery on %s failed verification %s, retry %d/%d", dst.ID(), dm, i, hac.RecoveryMaxRetries)
+		err := hac.retryItem(ctx, ri)
+		if ctx.Err() != nil {
+			return false
+		}
+		if err != nil {
+			dm.Error = err.Error()
+			continue
+		}
+		if verifyMeasurement(src.Monitor, dst.Monitor, dm) {
+			log.Infof("HACLUSTER: recovery on %s verified [%s|%s|%s] after %d retries", dst.ID(), dm.DB, dm.RP, dm.Measurement, i)
+			return true
+		}
+	}
+	log.Errorf("HACLUSTER: recovery on %s failed verification %s after %d retries", dst.ID(), dm, hac.RecoveryMaxRetries)
+	if hac.RetryQueue != nil {
+		ri.LastError = dm.String()
+		hac.RetryQueue.Add(ri)
+	}
+	return false
+}
 
-	//check Master
+// clearMismatch removes the dm mismatch from the dst node once its data has been verified
+func (hac *HACluster) clearMismatch(dst *HANode, dm *DataMismatch) {
+	hac.statsData.Lock()
+	for k, m := range dst.Mismatches {
+		if m.DB == dm.DB && m.RP == dm.RP && m.Measurement == dm.Measurement && m.Start.Equal(dm.Start) && m.End.Equal(dm.End) {
+			log.Infof("HACLUSTER: verified %s on %s", m, dst.ID())
+			dst.Mismatches = append(dst.Mismatches[:k], dst.Mismatches[k+1:]...)
+			break
+		}
+	}
+	hac.ClusterState = hac.currentState()
+	hac.statsData.Unlock()
 
-	lastMaster, lastmaOK, durationM := hac.Master.GetState()
-	lastSlave, lastslOK, durationS := hac.Slave.GetState()
+	if err := hac.SaveState(); err != nil {
+		log.Errorf("HACLUSTER: error on save cluster state: %s", err)
+	}
+}
 
-	// STATES
-	// ALL OK
-	// DETECTED SLAVE DONW
-	// STILL DOWN
-	// DETECTED UP
-	// RECOVERING
+func (hac *HACluster) checkCluster() {
 
 	log.Info("HACluster check....")
 
-	switch {
-	//detected Down
-	case hac.SlaveStateOK && lastSlave != true:
-		log.Infof("HACLuster: detected DOWN Last(%s) Duratio OK (%s)", lastslOK.String(), durationS.String())
-		hac.statsData.Lock()
-		hac.ClusterState = "CHECK_SLAVE_DOWN"
-		hac.MasterStateOK = lastMaster
-		hac.MasterLastOK = lastmaOK
-		hac.MasterCheckDuration = durationM
-		hac.SlaveLastOK = lastslOK
-		hac.SlaveStateOK = lastSlave
-		hac.SlaveCheckDuration = durationS
-		hac.statsData.Unlock()
-		return
-	//still Down
-	case hac.ClusterState == "CHECK_SLAVE_DOWN" && lastSlave == false:
-		hac.statsData.Lock()
-		hac.MasterStateOK = lastMaster
-		hac.MasterLastOK = lastmaOK
-		hac.MasterCheckDuration = durationM
-		hac.statsData.Unlock()
-		return
-	// Detected UP
-	case hac.ClusterState == "CHECK_SLAVE_DOWN" && lastSlave == true:
-		log.Infof("HACLuster: detected UP Last(%s) Duratio OK (%s) RECOVERING", lastslOK.String(), durationS.String())
-		// service has been recovered is time to sincronize
+	hac.statsData.Lock()
+	// node transitions
+	for _, n := range hac.Nodes {
+		last, lastOK, duration := n.Monitor.GetState()
+		if n.Maintenance {
+			// outage window is handled by the maintenance begin/end actions
+			n.StateOK = last
+			n.LastOK = lastOK
+			n.CheckDuration = duration
+			continue
+		}
+		switch {
+		case n.StateOK && !last:
+			log.Infof("HACLuster: detected DOWN on %s Last(%s) Duratio OK (%s)", n.ID(), lastOK.String(), duration.String())
+			n.openOutage(lastOK.Add(-hac.RecoveryPrePadding))
+		case !n.StateOK && last:
+			log.Infof("HACLuster: detected UP on %s Last(%s) Duratio OK (%s)", n.ID(), lastOK.String(), duration.String())
+			n.closeOutage(lastOK.Add(hac.RecoveryPostPadding))
+			hac.dropMasterOutages(n)
+		}
+		n.StateOK = last
+		n.LastOK = lastOK
+		n.CheckDuration = duration
+	}
 
-		hac.statsData.Lock()
-		startTime := hac.SlaveLastOK.Add(-hac.CheckInterval)
-
-		hac.ClusterState = "RECOVERING"
-		hac.MasterStateOK = lastMaster
-		hac.MasterLastOK = lastmaOK
-		hac.MasterCheckDuration = durationM
-		hac.SlaveLastOK = lastslOK
-		hac.SlaveStateOK = lastSlave
-		hac.SlaveCheckDuration = durationS
-		hac.statsData.Unlock()
+	// schedule recoveries on healthy nodes with pending outages
+	// not yet recovering, new outages on recovering nodes
+	// will wait in queue until the current job ends
+	src := hac.source()
+	jobs := []*HANode{}
+	for _, n := range hac.Nodes {
+		if n == src || !n.StateOK || n.Maintenance || len(n.Recovering) > 0 {
+			continue
+		}
+		windows := n.takeOutages(hac.CheckInterval)
+		if len(windows) == 0 {
+			continue
+		}
+		for _, ow := range windows {
+			log.Infof("HACLUSTER: scheduling recovery on %s from %s FROM [ %s ] TO [ %s ]", n.ID(), src.ID(), ow.Start.String(), ow.End.String())
+		}
+		n.Recovering = windows
+		jobs = append(jobs, n)
+	}
 
-		endTime := lastslOK
-
-		// after conection recover with de database the
-		// the client should be updated before any connection test
-		hac.Slave.UpdateCli()
-		// begin recover
-		log.Infof("HACLUSTER: INIT RECOVERY : FROM [ %s ] TO [ %s ]", startTime.String(), endTime.String())
-		start := time.Now()
-		//refresh schema
-		log.Infof("HACLUSTER: INIT REFRESH SCHEMA")
-		hac.Schema, _ = hac.GetSchema("", "", "")
-		log.Infof("HACLUSTER: INIT REPLICATION DATA PROCESS")
-		hac.ReplicateData(hac.Schema, startTime, endTime)
-		elapsed := time.Since(start)
-		log.Infof("HACLUSTER: DATA SYNCRONIZATION Took %s", elapsed.String())
+	state := hac.currentState()
+	if state == ClusterStateAllDown && hac.ClusterState != ClusterStateAllDown {
+		log.Warnf("HACLUSTER: ALL NODES DOWN")
+	}
+	hac.ClusterState = state
+	hac.statsData.Unlock()
 
-		hac.statsData.Lock()
-		hac.ClusterState = "OK"
-		hac.ClusterNumRecovers++
-		hac.ClusterLastRecoverDuration = elapsed
-		hac.statsData.Unlock()
-		return
-	//Detected Recover
-	case hac.ClusterState == "RECOVERING":
-		log.Infof("HACluster: Database Still recovering")
-		hac.statsData.Lock()
-		hac.MasterStateOK = lastMaster
-		hac.MasterLastOK = lastmaOK
-		hac.MasterCheckDuration = durationM
-		hac.SlaveLastOK = lastslOK
-		hac.SlaveStateOK = lastSlave
-		hac.SlaveCheckDuration = durationS
-		hac.statsData.Unlock()
-		return
-	case hac.ClusterState == "OK" && lastSlave == true:
-		hac.statsData.Lock()
-		hac.MasterStateOK = lastMaster
-		hac.MasterLastOK = lastmaOK
-		hac.MasterCheckDuration = durationM
-		hac.SlaveLastOK = lastslOK
-		hac.SlaveStateOK = lastSlave
-		hac.SlaveCheckDuration = durationS
-		hac.statsData.Unlock()
-	default:
-		log.Warnf("HACLUSTER: undhanled State Last MasterOK %t %s", lastMaster, lastmaOK.String())
-		log.Warnf("HACLUSTER: undhanled State Last SlaveOK %t %s", lastSlave, lastslOK.String())
-		return
+	if err := hac.SaveState(); err != nil {
+		log.Errorf("HACLUSTER: error on save cluster state: %s", err)
 	}
 
+	for _, n := range jobs {
+		hac.recoverWg.Add(1)
+		go hac.recoverJob(src, n)
+	}
 }
 
 func (hac *HACluster) startSupervisorGo(wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	log.Infof("Beginning Supervision process  process each %s ", hac.CheckInterval.String())
-	hac.MasterStateOK, hac.MasterLastOK, _ = hac.Master.GetState()
-	hac.SlaveStateOK, hac.SlaveLastOK, _ = hac.Slave.GetState()
+	hac.statsData.Lock()
+	for _, n := range hac.Nodes {
+		n.StateOK, n.LastOK, _ = n.Monitor.GetState()
+	}
+	hac.statsData.Unlock()
 
 	t := time.NewTicker(hac.CheckInterval)
 	for {
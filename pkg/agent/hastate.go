@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// clusterStateFile is the file name (in DataDir) where the HA cluster state is persisted
+const clusterStateFile = "hacluster.json"
+
+// clusterState is the persisted HA cluster state
+type clusterState struct {
+	ClusterState               string
+	ClusterNumRecovers         int
+	ClusterLastRecoverDuration time.Duration
+	Nodes                      map[string]*nodeState
+}
+
+// nodeState is the persisted state of a cluster node
+type nodeState struct {
+	StateOK     bool
+	LastOK      time.Time
+	Maintenance bool
+	Outages     []*OutageWindow
+	// Mismatches are kept until their queued retries are verified
+	Mismatches []*DataMismatch
+}
+
+func (hac *HACluster) stateFile() string {
+	return filepath.Join(hac.DataDir, clusterStateFile)
+}
+
+// SaveState persists the cluster state, the pending outage windows and the mismatches of all nodes
+func (hac *HACluster) SaveState() error {
+	if len(hac.DataDir) == 0 {
+		return nil
+	}
+
+	hac.statsData.RLock()
+	st := &clusterState{
+		ClusterState:               hac.ClusterState,
+		ClusterNumRecovers:         hac.ClusterNumRecovers,
+		ClusterLastRecoverDuration: hac.ClusterLastRecoverDuration,
+		Nodes:                      make(map[string]*nodeState, len(hac.Nodes)),
+	}
+	for _, n := range hac.Nodes {
+		// windows being recovered are saved as pending, if syncflux
+		// stops before the recovery ends they will be recovered again
+		outages := append([]*OutageWindow{}, n.Recovering...)
+		var open *OutageWindow
+		for _, ow := range n.Outages {
+			if ow.End.IsZero() {
+				// the open window is not merged, it would be saved as closed
+				open = ow
+				continue
+			}
+			outages = append(outages, ow)
+		}
+		outages = mergeOutages(outages, 0)
+		if open != nil {
+			c := *open
+			outages = append(outages, &c)
+		}
+		st.Nodes[n.ID()] = &nodeState{StateOK: n.StateOK, LastOK: n.LastOK, Maintenance: n.Maintenance, Outages: outages, Mismatches: append([]*DataMismatch{}, n.Mismatches...)}
+	}
+	hac.statsData.RUnlock()
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(hac.DataDir, 0755); err != nil {
+		return err
+	}
+	// write to a temporary file and rename to avoid corrupt state files
+	tmp := hac.stateFile() + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, hac.stateFile())
+}
+
+// LoadState restores the recovery counters and the pending outage windows
+// saved by a previous run. Outages still open when syncflux stopped are
+// closed at the current last OK time of the node, so they will be recovered
+// on the first cluster check, or kept open if the node is still down.
+func (hac *HACluster) LoadState() error {
+	if len(hac.DataDir) == 0 {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(hac.stateFile())
+	if os.IsNotExist(err) {
+		log.Infof("HACLUSTER: no previous state found in %s", hac.stateFile())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	st := &clusterState{}
+	if err := json.Unmarshal(data, st); err != nil {
+		return err
+	}
+
+	hac.statsData.Lock()
+	defer hac.statsData.Unlock()
+
+	log.Infof("HACLUSTER: restoring previous state %s from %s", st.ClusterState, hac.stateFile())
+	hac.ClusterNumRecovers = st.ClusterNumRecovers
+	hac.ClusterLastRecoverDuration = st.ClusterLastRecoverDuration
+
+	for k, n := range hac.Nodes {
+		ns, ok := st.Nodes[n.ID()]
+		if !ok {
+			continue
+		}
+		n.Mismatches = ns.Mismatches
+		if ns.Maintenance {
+			// keep the maintenance window open until the maintenance ends
+			log.Infof("HACLUSTER: restored maintenance mode on %s", n.ID())
+			n.Maintenance = true
+			n.Outages = ns.Outages
+			continue
+		}
+		for _, ow := range ns.Outages {
+			if ow.End.IsZero() && n.StateOK {
+				ow.End = n.LastOK
+			}
+			if k == 0 && hac.SyncMode != "twoway" {
+				log.Warnf("HACLUSTER: MASTER %s lost data FROM [ %s ] TO [ %s ] will not be recovered on sync-mode %s", n.ID(), ow.Start.String(), ow.End.String(), hac.SyncMode)
+				continue
+			}
+			log.Infof("HACLUSTER: restored pending outage on %s FROM [ %s ] TO [ %s ]", n.ID(), ow.Start.String(), ow.End.String())
+			n.Outages = append(n.Outages, ow)
+		}
+	}
+	return nil
+}
+
+// openDownOutages opens an outage window on the nodes down on start, unless
+// they have one still open restored from the previous state
+func (hac *HACluster) openDownOutages() {
+	hac.statsData.Lock()
+	defer hac.statsData.Unlock()
+	for _, n := range hac.Nodes {
+		if n.StateOK || n.Maintenance {
+			continue
+		}
+		if l := len(n.Outages); l > 0 && n.Outages[l-1].End.IsZero() {
+			continue
+		}
+		log.Warnf("HACLUSTER: %s is down on start, opening outage window", n.ID())
+		n.openOutage(time.Now().Add(-hac.RecoveryPrePadding))
+	}
+}
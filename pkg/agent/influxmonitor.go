@@ -1,7 +1,11 @@
 package agent
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -9,34 +13,69 @@ import (
 	"github.com/toni-moreno/syncflux/pkg/config"
 )
 
+// maxClockSkew is the clock skew from where a warning is logged
+const maxClockSkew = 2 * time.Second
+
 type InfluxMonitor struct {
-	cfg               *config.InfluxDB
-	CheckInterval     time.Duration
+	cfg           *config.InfluxDB
+	CheckInterval time.Duration
+	// DownThreshold is the number of consecutive failed checks to declare the node down
+	DownThreshold int
+	// UpThreshold is the number of consecutive successful checks to declare the node up
+	UpThreshold       int
+	numFails          int
+	numOKs            int
 	lastOK            time.Time
 	lastStateDuration time.Duration
 	statusOK          bool
 	Version           string
 	PingDuration      time.Duration
-	statsData         sync.RWMutex
-	cli               client.Client
-	lastcli           client.Client
-	climutex          sync.RWMutex
+	// ClockSkew is the InfluxDB server clock minus the syncflux clock
+	ClockSkew time.Duration
+	statsData sync.RWMutex
+	cli       client.Client
+	lastcli   client.Client
+	climutex  sync.RWMutex
 }
 
 func (im *InfluxMonitor) setStatError() {
 	im.statsData.Lock()
 	defer im.statsData.Unlock()
+	im.numOKs = 0
+	im.numFails++
+	if im.statusOK && im.numFails < im.DownThreshold {
+		log.Infof("InfluxMonitor: InfluxDB : %s failed check %d/%d still considered UP", im.cfg.Name, im.numFails, im.DownThreshold)
+		return
+	}
 	im.statusOK = false
-
 }
 
 func (im *InfluxMonitor) setStatOK(t time.Duration, version string) {
 	im.statsData.Lock()
 	defer im.statsData.Unlock()
 	im.lastOK = time.Now()
-	im.statusOK = true
 	im.Version = version
 	im.PingDuration = t
+	im.numFails = 0
+	im.numOKs++
+	if !im.statusOK && im.numOKs < im.UpThreshold {
+		log.Infof("InfluxMonitor: InfluxDB : %s successful check %d/%d still considered DOWN", im.cfg.Name, im.numOKs, im.UpThreshold)
+		return
+	}
+	im.statusOK = true
+}
+
+func (im *InfluxMonitor) setClockSkew(skew time.Duration) {
+	im.statsData.Lock()
+	defer im.statsData.Unlock()
+	im.ClockSkew = skew
+}
+
+// GetClockSkew returns the last measured clock skew of the InfluxDB server
+func (im *InfluxMonitor) GetClockSkew() time.Duration {
+	im.statsData.RLock()
+	defer im.statsData.RUnlock()
+	return im.ClockSkew
 }
 
 func (im *InfluxMonitor) GetState() (bool, time.Time, time.Duration) {
@@ -60,7 +99,7 @@ func (im *InfluxMonitor) InitPing() (client.Client, time.Duration, string, error
 		return nil, 0, "", err2
 	}
 
-	dur, ver, err3 := con.Ping(im.cfg.Timeout)
+	dur, ver, err3 := im.ping()
 	if err3 != nil {
 		log.Errorf("Fail to build newclient to database %s, error: %s\n", im.cfg.Location, err3)
 		return nil, 0, "", err3
@@ -149,14 +188,62 @@ func (im *InfluxMonitor) Ping() (time.Duration, string, error) {
 	return dur, ver, nil
 }
 
+// ping checks the InfluxDB server as client Ping does and measures the clock
+// difference between the server and syncflux from the HTTP Date header of the
+// response. Date header has seconds precision so the skew is only accurate to +/- 0.5s
+func (im *InfluxMonitor) ping() (time.Duration, string, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(im.cfg.Location, "/")+"/ping", nil)
+	if err != nil {
+		return 0, "", err
+	}
+	if len(im.cfg.AdminUser) > 0 {
+		req.SetBasicAuth(im.cfg.AdminUser, im.cfg.AdminPasswd)
+	}
+	if im.cfg.Timeout > 0 {
+		params := req.URL.Query()
+		params.Set("wait_for_leader", fmt.Sprintf("%.0fs", im.cfg.Timeout.Seconds()))
+		req.URL.RawQuery = params.Encode()
+	}
+	c := http.Client{Timeout: im.cfg.Timeout}
+	s := time.Now()
+	resp, err := c.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return 0, "", err
+	}
+	elapsed := time.Since(s)
+	if resp.StatusCode != http.StatusNoContent {
+		return 0, "", errors.New(string(body))
+	}
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		log.Warnf("InfluxMonitor: InfluxDB : %s can not check clock skew (Error :%s )", im.cfg.Name, err)
+	} else {
+		// compare the middle of the request with the middle of the Date second
+		im.setClockSkew(date.Add(500 * time.Millisecond).Sub(s.Add(elapsed / 2)))
+	}
+	return elapsed, resp.Header.Get("X-Influxdb-Version"), nil
+}
+
 func (im *InfluxMonitor) GetStat() {
-	_, dur, ver, err := im.InitPing()
+	cli, dur, ver, err := im.InitPing()
 	if err != nil {
 		log.Warnf("InfluxMonitor: InfluxDB : %s  NO OK (Error :%s )", im.cfg.Name, err)
 		im.setStatError()
 	} else {
+		if im.GetCli() == nil {
+			// the node was down on start
+			im.SetCli(cli)
+		}
 		log.Infof("InfluxMonitor: InfluxDB : %s  OK (Version  %s : Duration %s )", im.cfg.Name, ver, dur.String())
 		im.setStatOK(dur, ver)
+		if skew := im.GetClockSkew(); skew > maxClockSkew || skew < -maxClockSkew {
+			log.Warnf("InfluxMonitor: InfluxDB : %s clock skew detected  %s", im.cfg.Name, skew.String())
+		}
 	}
 }
 
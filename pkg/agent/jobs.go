@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job is a running copy (copy actions, initial replications, recoveries
+// and retries) that can be cancelled
+type Job struct {
+	ID      int64
+	Name    string
+	Started time.Time
+	cancel  context.CancelFunc
+}
+
+var (
+	jobsCtx, jobsCancel = context.WithCancel(context.Background())
+	jobsMutex           sync.Mutex
+	jobsWg              sync.WaitGroup
+	jobsClosed          bool
+	jobsNextID          int64
+	jobs                = make(map[int64]*Job)
+)
+
+// startJob registers a new job and returns its context and the function
+// to call when the job ends, after End the returned context is cancelled
+func startJob(name string) (context.Context, func()) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	ctx, cancel := context.WithCancel(jobsCtx)
+	if jobsClosed {
+		cancel()
+		return ctx, func() {}
+	}
+	jobsNextID++
+	j := &Job{ID: jobsNextID, Name: name, Started: time.Now(), cancel: cancel}
+	jobs[j.ID] = j
+	jobsWg.Add(1)
+	log.Infof("JOB #%d %s started", j.ID, j.Name)
+	return ctx, func() {
+		jobsMutex.Lock()
+		delete(jobs, j.ID)
+		jobsMutex.Unlock()
+		cancel()
+		if ctx.Err() == context.Canceled && jobsCtx.Err() == nil {
+			log.Warnf("JOB #%d %s cancelled after %s", j.ID, j.Name, time.Since(j.Started).String())
+		} else {
+			log.Infof("JOB #%d %s ended after %s", j.ID, j.Name, time.Since(j.Started).String())
+		}
+		jobsWg.Done()
+	}
+}
+
+// ListJobs returns the running jobs sorted by ID
+func ListJobs() []Job {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	list := make([]Job, 0, len(jobs))
+	for _, j := range jobs {
+		list = append(list, *j)
+	}
+	sort.Slice(list, func(i, k int) bool { return list[i].ID < list[k].ID })
+	return list
+}
+
+// CancelJob cancels the job with id, its running batches finish and its progress is saved
+func CancelJob(id int64) error {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	j, ok := jobs[id]
+	if !ok {
+		return fmt.Errorf("job %d not found", id)
+	}
+	log.Warnf("JOB #%d %s cancelling...", j.ID, j.Name)
+	j.cancel()
+	return nil
+}
+
+// cancelJobs cancels all the running jobs and waits until they end, new jobs will be cancelled at once
+func cancelJobs() {
+	jobsMutex.Lock()
+	jobsClosed = true
+	log.Infof("Cancelling %d running jobs...", len(jobs))
+	jobsMutex.Unlock()
+	jobsCancel()
+	jobsWg.Wait()
+}
+
+// sleepCtx sleeps for d or until ctx is cancelled, returning the ctx error
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
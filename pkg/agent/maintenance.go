@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+)
+
+// StartMaintenance puts the node id in maintenance mode: it is excluded from the
+// active nodes and its outage window begins now
+func (hac *HACluster) StartMaintenance(id string) error {
+	n := hac.node(id)
+	if n == nil {
+		return fmt.Errorf("node %s not found in cluster", id)
+	}
+
+	hac.statsData.Lock()
+	if n.Maintenance {
+		hac.statsData.Unlock()
+		return fmt.Errorf("node %s is already in maintenance", id)
+	}
+	now := time.Now()
+	log.Infof("HACLUSTER: BEGIN MAINTENANCE on %s at %s", id, now.String())
+	n.Maintenance = true
+	// a down node has already an open outage window
+	if n.StateOK {
+		n.openOutage(now)
+	}
+	hac.ClusterState = hac.currentState()
+	hac.statsData.Unlock()
+
+	if err := hac.SaveState(); err != nil {
+		log.Errorf("HACLUSTER: error on save cluster state: %s", err)
+	}
+	return nil
+}
+
+// EndMaintenance ends the maintenance mode of node id closing its outage window now,
+// the window will be recovered on the next cluster check
+func (hac *HACluster) EndMaintenance(id string) error {
+	n := hac.node(id)
+	if n == nil {
+		return fmt.Errorf("node %s not found in cluster", id)
+	}
+
+	hac.statsData.Lock()
+	if !n.Maintenance {
+		hac.statsData.Unlock()
+		return fmt.Errorf("node %s is not in maintenance", id)
+	}
+	now := time.Now()
+	log.Infof("HACLUSTER: END MAINTENANCE on %s at %s", id, now.String())
+	n.Maintenance = false
+	n.StateOK, n.LastOK, n.CheckDuration = n.Monitor.GetState()
+	if n.StateOK {
+		n.closeOutage(now)
+		hac.dropMasterOutages(n)
+	} else {
+		// node still down: window will be closed when detected up
+		log.Warnf("HACLUSTER: node %s is still down after maintenance", id)
+	}
+	hac.ClusterState = hac.currentState()
+	hac.statsData.Unlock()
+
+	if err := hac.SaveState(); err != nil {
+		log.Errorf("HACLUSTER: error on save cluster state: %s", err)
+	}
+	return nil
+}
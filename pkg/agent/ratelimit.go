@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimit is a token bucket limiting the rate of some units per second
+// (0 means unlimited), waits are shared by all the goroutines using it
+type rateLimit struct {
+	mutex  sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+func (rl *rateLimit) setRate(rate int64) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	rl.rate = rate
+	rl.tokens = 0
+	rl.last = time.Now()
+}
+
+func (rl *rateLimit) getRate() int64 {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	return rl.rate
+}
+
+// wait blocks until n units can be used without exceeding the rate or ctx is
+// cancelled. Bursts up to one second of rate are allowed and requests greater
+// than the rate are allowed too, but the next ones will wait until the debt is paid.
+func (rl *rateLimit) wait(ctx context.Context, n int64) error {
+	rl.mutex.Lock()
+	if rl.rate <= 0 {
+		rl.mutex.Unlock()
+		return nil
+	}
+	rate := float64(rl.rate)
+	now := time.Now()
+	rl.tokens += now.Sub(rl.last).Seconds() * rate
+	if rl.tokens > rate {
+		rl.tokens = rate
+	}
+	rl.last = now
+	rl.tokens -= float64(n)
+	var delay time.Duration
+	if rl.tokens < 0 {
+		delay = time.Duration(-rl.tokens / rate * float64(time.Second))
+	}
+	rl.mutex.Unlock()
+	if delay == 0 {
+		return nil
+	}
+	return sleepCtx(ctx, delay)
+}
+
+// RateLimits are the max rates per second for all the copy workers, 0 means unlimited
+type RateLimits struct {
+	PointsPerSecond  int64
+	BytesPerSecond   int64
+	QueriesPerSecond int64
+}
+
+var (
+	pointsLimit  rateLimit
+	bytesLimit   rateLimit
+	queriesLimit rateLimit
+)
+
+// waitQuery waits for the queries rate limit on the query helpers without
+// a job context, the wait is cancelled with all the jobs on End
+func waitQuery() error {
+	return queriesLimit.wait(jobsCtx, 1)
+}
+
+// SetRateLimits changes the copy rate limits, they are applied at once to all running copies
+func SetRateLimits(rl RateLimits) {
+	log.Infof("Setting rate limits to %d points/s %d bytes/s %d queries/s", rl.PointsPerSecond, rl.BytesPerSecond, rl.QueriesPerSecond)
+	pointsLimit.setRate(rl.PointsPerSecond)
+	bytesLimit.setRate(rl.BytesPerSecond)
+	queriesLimit.setRate(rl.QueriesPerSecond)
+}
+
+// GetRateLimits returns the current copy rate limits
+func GetRateLimits() RateLimits {
+	return RateLimits{
+		PointsPerSecond:  pointsLimit.getRate(),
+		BytesPerSecond:   bytesLimit.getRate(),
+		QueriesPerSecond: queriesLimit.getRate(),
+	}
+}
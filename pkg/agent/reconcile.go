@@ -0,0 +1,165 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/v2"
+)
+
+// From Master to Slave
+func (hac *HACluster) ReconcileData(ctx context.Context, schema []*InfluxSchDb, start time.Time, end time.Time) error {
+	return hac.reconcileData(ctx, hac.Master, hac.Slave, schema, start, end, false)
+}
+
+// From Master to Slave
+func (hac *HACluster) ReconcileDataFull(ctx context.Context, schema []*InfluxSchDb) error {
+	return hac.reconcileData(ctx, hac.Master, hac.Slave, schema, time.Time{}, time.Time{}, true)
+}
+
+// reconcileData compares src and dst data for all the schema in the [start,end) period
+// (or all the retention period if full) and copies only the windows that differ
+func (hac *HACluster) reconcileData(ctx context.Context, src *InfluxMonitor, dst *InfluxMonitor, schema []*InfluxSchDb, start time.Time, end time.Time, full bool) error {
+	for _, db := range schema {
+		for _, rp := range db.Rps {
+			if ctx.Err() != nil {
+				log.Warnf("Reconcile cancelled")
+				return ctx.Err()
+			}
+			log.Infof("Reconciling Data from DB %s RP %s [%s -> %s]...", db.Name, rp.Name, src.cfg.Name, dst.cfg.Name)
+			rn := *rp
+			if rp.Def {
+				rn.Name = db.NewDefRp
+			}
+			s, e := start, end
+			if full {
+				s, e = rp.GetFirstLastTime(hac.MaxRetentionInterval)
+			}
+			report := hac.reconcileDBRP(ctx, src, dst, db, rp, &rn, s, e)
+			if len(report.BadChunks) > 0 {
+				r, w, t := report.RWErrors()
+				log.Errorf("Data Reconcile error in DB [%s] RP [%s] | Registered %d Read %d Write | %d Total Errors", db.Name, rn.Name, r, w, t)
+			}
+		}
+	}
+	return nil
+}
+
+// countDigest returns as DigestByTime the number of points of measurement meas in the [start,end)
+// period for each group by time interval with points, indexed by the interval start time in seconds
+func countDigest(c client.Client, sdb string, rp string, meas string, fields map[string]*FieldSch, start time.Time, end time.Time, group time.Duration) (map[int64]string, error) {
+	counts, err := CountPointsByTime(c, sdb, rp, meas, start, end, group)
+	if err != nil {
+		return nil, err
+	}
+	digests := make(map[int64]string)
+	for _, pc := range counts {
+		if pc.Count > 0 {
+			digests[pc.Time.Unix()] = fmt.Sprint(pc.Count)
+		}
+	}
+	return digests, nil
+}
+
+// reconcileDBRP compares for each measurement in srp the digest (count and sum of numeric
+// fields) of each ChunkDuration window in src and dst and copies the windows that differ
+func (hac *HACluster) reconcileDBRP(ctx context.Context, src *InfluxMonitor, dst *InfluxMonitor, db *InfluxSchDb, srp *RetPol, drp *RetPol, start time.Time, end time.Time) *SyncReport {
+	report := &SyncReport{
+		SrcSrv: src.cfg.Name,
+		DstSrv: dst.cfg.Name,
+		SrcDB:  db.Name,
+		DstDB:  db.NewName,
+		SrcRP:  srp.Name,
+		DstRP:  drp.Name,
+		Start:  start,
+		End:    end,
+	}
+	s := time.Now()
+	window := int64(hac.ChunkDuration)
+	min := bisectMinWindow()
+
+	for m, sch := range srp.Measurements {
+		if ctx.Err() != nil {
+			report.Cancelled = true
+			break
+		}
+		digest := DigestByTime
+		if transformsFields(m) {
+			// fields are renamed or dropped on dst, only the number of points can be compared
+			digest = countDigest
+		}
+		sd, err := digest(src.cli, db.Name, srp.Name, m, sch.Fields, start, end, hac.ChunkDuration)
+		if err != nil {
+			log.Errorf("RECONCILE: error on get digest for %s[%s|%s|%s]: %s", src.cfg.Name, db.Name, srp.Name, m, err)
+			continue
+		}
+		dd, err := digest(dst.cli, db.NewName, drp.Name, sch.NewName, sch.Fields, start, end, hac.ChunkDuration)
+		if err != nil {
+			log.Errorf("RECONCILE: error on get digest for %s[%s|%s|%s]: %s", dst.cfg.Name, db.NewName, drp.Name, sch.NewName, err)
+			continue
+		}
+
+		// digests are indexed in seconds, windows in nanoseconds
+		windows := []int64{}
+		for t, d := range sd {
+			if dd[t] != d {
+				windows = append(windows, t*int64(time.Second))
+			}
+		}
+		for t := range dd {
+			if _, ok := sd[t]; !ok {
+				log.Warnf("RECONCILE: %s[%s|%s|%s] has data not found in %s FROM [ %s ] TO [ %s ]", dst.cfg.Name, db.NewName, drp.Name, sch.NewName, src.cfg.Name, time.Unix(t, 0).String(), time.Unix(t, 0).Add(hac.ChunkDuration).String())
+			}
+		}
+		if len(windows) == 0 {
+			log.Debugf("RECONCILE: [%s|%s|%s] %d windows are equal", db.Name, srp.Name, m, len(sd))
+			continue
+		}
+		log.Infof("RECONCILE: [%s|%s|%s] %d of %d windows differ", db.Name, srp.Name, m, len(windows), len(sd))
+
+		// merge adjacent windows in ranges to copy
+		sort.Slice(windows, func(i, j int) bool { return windows[i] < windows[j] })
+		ranges := [][2]int64{}
+		for _, t := range windows {
+			if l := len(ranges); l > 0 && ranges[l-1][1] == t {
+				ranges[l-1][1] = t + window
+				continue
+			}
+			ranges = append(ranges, [2]int64{t, t + window})
+		}
+		if !oldestFirst() {
+			reverseRanges(ranges)
+		}
+
+		measurements := map[string]*MeasurementSch{m: sch}
+		for i, r := range ranges {
+			if ctx.Err() != nil {
+				report.Cancelled = true
+				break
+			}
+			// windows are aligned by group by time and can begin before start or end after end
+			if r[0] < start.UnixNano() {
+				r[0] = start.UnixNano()
+			}
+			if r[1] > end.UnixNano() {
+				r[1] = end.UnixNano()
+			}
+			chrep := syncChunk(ctx, src, dst, db.Name, db.NewName, srp, drp, measurements, r[0], r[1], report, nil)
+			chrep.Num = int64(i + 1)
+			chrep.Total = int64(len(ranges))
+			chrep.Log("Reconciled Chunk")
+			report.TotalPoints += chrep.ProcessedPoints
+			report.ChunkReport = append(report.ChunkReport, chrep)
+			if chrep.ReadErrors+chrep.WriteErrors > 0 && ctx.Err() == nil {
+				report.BadChunks = append(report.BadChunks, bisectChunk(ctx, src, dst, db.Name, db.NewName, srp, drp, chrep, min, report)...)
+			}
+		}
+	}
+
+	report.TotalElapsed = time.Since(s)
+	report.Log("Reconciled DB")
+	report.LogBadChunks()
+	return report
+}
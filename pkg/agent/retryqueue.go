@@ -0,0 +1,338 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// retryQueueFile is the file name (in DataDir) where the retry queue is persisted
+const retryQueueFile = "retryqueue.json"
+
+// RetryItem is a measurement chunk that could not be copied and waits to be retried
+type RetryItem struct {
+	ID          int64
+	Src         string
+	Dst         string
+	SrcDB       string
+	DstDB       string
+	SrcRP       string
+	DstRP       string
+	Measurement string
+	Start       time.Time
+	End         time.Time
+	LastError   string
+	// Verify is set on the items queued by a failed recovery verification, they are
+	// only done when the measurement points match after the copy
+	Verify    bool
+	Retries   int
+	Created   time.Time
+	NextRetry time.Time
+}
+
+func (ri *RetryItem) String() string {
+	return fmt.Sprintf("#%d [%s -> %s] [%s|%s|%s] FROM [ %s ] TO [ %s ]", ri.ID, ri.Src, ri.Dst, ri.SrcDB, ri.SrcRP, ri.Measurement, ri.Start.String(), ri.End.String())
+}
+
+// RetryQueue is a durable queue of chunks that failed recovery
+type RetryQueue struct {
+	// Interval is the first retry delay, doubled on each failed retry up to MaxBackoff
+	Interval   time.Duration
+	MaxBackoff time.Duration
+	file       string
+	mutex      sync.Mutex
+	nextID     int64
+	items      map[int64]*RetryItem
+}
+
+// NewRetryQueue creates a retry queue persisted in dir and loads the pending items
+func NewRetryQueue(dir string, interval time.Duration, maxBackoff time.Duration) *RetryQueue {
+	rq := &RetryQueue{
+		Interval:   interval,
+		MaxBackoff: maxBackoff,
+		items:      make(map[int64]*RetryItem),
+	}
+	if len(dir) > 0 {
+		rq.file = filepath.Join(dir, retryQueueFile)
+	}
+	if err := rq.load(); err != nil {
+		log.Errorf("RETRYQUEUE: error on load queue from %s: %s", rq.file, err)
+	}
+	return rq
+}
+
+func (rq *RetryQueue) load() error {
+	if len(rq.file) == 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(rq.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	items := []*RetryItem{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	rq.mutex.Lock()
+	defer rq.mutex.Unlock()
+	for _, ri := range items {
+		rq.items[ri.ID] = ri
+		if ri.ID >= rq.nextID {
+			rq.nextID = ri.ID + 1
+		}
+	}
+	log.Infof("RETRYQUEUE: loaded %d pending items from %s", len(items), rq.file)
+	return nil
+}
+
+// save persists the queue, rq.mutex should be locked by the caller
+func (rq *RetryQueue) save() {
+	if len(rq.file) == 0 {
+		return
+	}
+	data, err := json.MarshalIndent(rq.list(), "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(rq.file), 0755)
+	}
+	if err == nil {
+		tmp := rq.file + ".tmp"
+		err = ioutil.WriteFile(tmp, data, 0644)
+		if err == nil {
+			err = os.Rename(tmp, rq.file)
+		}
+	}
+	if err != nil {
+		log.Errorf("RETRYQUEUE: error on save queue to %s: %s", rq.file, err)
+	}
+}
+
+// list returns the items sorted by ID, rq.mutex should be locked by the caller
+func (rq *RetryQueue) list() []*RetryItem {
+	items := make([]*RetryItem, 0, len(rq.items))
+	for _, ri := range rq.items {
+		items = append(items, ri)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items
+}
+
+// List returns a copy of all the items in the queue
+func (rq *RetryQueue) List() []RetryItem {
+	rq.mutex.Lock()
+	defer rq.mutex.Unlock()
+	items := []RetryItem{}
+	for _, ri := range rq.list() {
+		items = append(items, *ri)
+	}
+	return items
+}
+
+// Add queues a new item to be retried after Interval
+func (rq *RetryQueue) Add(ri *RetryItem) {
+	rq.mutex.Lock()
+	defer rq.mutex.Unlock()
+	ri.ID = rq.nextID
+	rq.nextID++
+	ri.Created = time.Now()
+	ri.NextRetry = ri.Created.Add(rq.Interval)
+	rq.items[ri.ID] = ri
+	log.Warnf("RETRYQUEUE: queued %s : %s", ri, ri.LastError)
+	rq.save()
+}
+
+// Retry schedules the item with id to be retried as soon as possible
+func (rq *RetryQueue) Retry(id int64) error {
+	rq.mutex.Lock()
+	defer rq.mutex.Unlock()
+	ri, ok := rq.items[id]
+	if !ok {
+		return fmt.Errorf("retry item %d not found", id)
+	}
+	ri.NextRetry = time.Now()
+	rq.save()
+	return nil
+}
+
+// Discard removes the item with id from the queue
+func (rq *RetryQueue) Discard(id int64) error {
+	rq.mutex.Lock()
+	defer rq.mutex.Unlock()
+	ri, ok := rq.items[id]
+	if !ok {
+		return fmt.Errorf("retry item %d not found", id)
+	}
+	log.Warnf("RETRYQUEUE: discarded %s", ri)
+	delete(rq.items, id)
+	rq.save()
+	return nil
+}
+
+// due returns a copy of the items whose next retry time has been reached
+func (rq *RetryQueue) due() []RetryItem {
+	rq.mutex.Lock()
+	defer rq.mutex.Unlock()
+	now := time.Now()
+	items := []RetryItem{}
+	for _, ri := range rq.list() {
+		if !ri.NextRetry.After(now) {
+			items = append(items, *ri)
+		}
+	}
+	return items
+}
+
+// done updates the item with the retry result, removing it if err is nil
+// or scheduling the next retry with exponential backoff
+func (rq *RetryQueue) done(id int64, err error) {
+	rq.mutex.Lock()
+	defer rq.mutex.Unlock()
+	ri, ok := rq.items[id]
+	if !ok {
+		// discarded while retrying
+		return
+	}
+	if err == nil {
+		log.Infof("RETRYQUEUE: recovered %s after %d retries", ri, ri.Retries+1)
+		delete(rq.items, id)
+		rq.save()
+		return
+	}
+	ri.Retries++
+	ri.LastError = err.Error()
+	backoff := rq.Interval << uint(ri.Retries)
+	if backoff > rq.MaxBackoff || backoff <= 0 {
+		backoff = rq.MaxBackoff
+	}
+	ri.NextRetry = time.Now().Add(backoff)
+	log.Warnf("RETRYQUEUE: retry %d failed for %s : %s , next retry in %s", ri.Retries, ri, ri.LastError, backoff.String())
+	rq.save()
+}
+
+// queueBadChunks adds to the queue all the failed measurements in the report bad chunks
+func (rq *RetryQueue) queueBadChunks(report *SyncReport) {
+	for _, bc := range report.BadChunks {
+		for _, ce := range bc.FailedMeas {
+			rq.Add(&RetryItem{
+				Src:         report.SrcSrv,
+				Dst:         report.DstSrv,
+				SrcDB:       report.SrcDB,
+				DstDB:       report.DstDB,
+				SrcRP:       report.SrcRP,
+				DstRP:       report.DstRP,
+				Measurement: ce.Measurement,
+				Start:       time.Unix(0, bc.TimeStart),
+				End:         time.Unix(0, bc.TimeEnd),
+				LastError:   ce.Err,
+			})
+		}
+	}
+}
+
+// retryItem copies again the item measurement data from its source to its destination node
+func (hac *HACluster) retryItem(ctx context.Context, ri *RetryItem) error {
+	src := hac.node(ri.Src)
+	dst := hac.node(ri.Dst)
+	if src == nil || dst == nil {
+		return fmt.Errorf("nodes %s , %s are not in the cluster", ri.Src, ri.Dst)
+	}
+	hac.statsData.RLock()
+	up := src.StateOK && dst.StateOK && !src.Maintenance && !dst.Maintenance
+	hac.statsData.RUnlock()
+	if !up {
+		return fmt.Errorf("nodes %s , %s are not both up", ri.Src, ri.Dst)
+	}
+
+	rps, err := GetRetentionPolicies(src.Monitor.cli, ri.SrcDB)
+	if err != nil {
+		return err
+	}
+	var srp *RetPol
+	for _, rp := range rps {
+		if rp.Name == ri.SrcRP {
+			srp = rp
+		}
+	}
+	if srp == nil {
+		return fmt.Errorf("retention policy %s not found on %s", ri.SrcRP, ri.Src)
+	}
+	m := &MeasurementSch{Name: ri.Measurement, NewName: transformMeasurement(ri.Measurement), Fields: GetFields(src.Monitor.cli, ri.SrcDB, ri.Measurement, ri.SrcRP)}
+	resolveFieldConflicts(src.Monitor.cli, ri.SrcDB, ri.SrcRP, m)
+	srp.Measurements = map[string]*MeasurementSch{ri.Measurement: m}
+	db := &InfluxSchDb{Name: ri.SrcDB, NewName: ri.DstDB, Rps: []*RetPol{srp}}
+	drp := *srp
+	drp.Name = ri.DstRP
+
+	report := Sync(ctx, src.Monitor, dst.Monitor, ri.SrcDB, ri.DstDB, srp, &drp, ri.Start, ri.End, db, hac.ChunkDuration, hac.MaxRetentionInterval, nil)
+	if report == nil {
+		return fmt.Errorf("error on sync data")
+	}
+	if report.Cancelled {
+		return ctx.Err()
+	}
+	if len(report.BadChunks) > 0 {
+		bc := report.BadChunks[0]
+		if len(bc.FailedMeas) > 0 {
+			return fmt.Errorf("%s", bc.FailedMeas[0])
+		}
+		return fmt.Errorf("chunk with %d read %d write errors", bc.ReadErrors, bc.WriteErrors)
+	}
+	return nil
+}
+
+// retryQueued retries the item, the Verify ones are verified after the copy and
+// their mismatch is removed from the destination node when the points match
+func (hac *HACluster) retryQueued(ctx context.Context, ri *RetryItem) error {
+	if err := hac.retryItem(ctx, ri); err != nil || !ri.Verify {
+		return err
+	}
+	src := hac.node(ri.Src)
+	dst := hac.node(ri.Dst)
+	dm := &DataMismatch{DB: ri.SrcDB, RP: ri.SrcRP, Measurement: ri.Measurement, Start: ri.Start, End: ri.End}
+	if !verifyMeasurement(src.Monitor, dst.Monitor, dm) {
+		return fmt.Errorf("failed verification %s", dm)
+	}
+	hac.clearMismatch(dst, dm)
+	return nil
+}
+
+// RetryQueueStart begins the background process which retries the due items
+func (hac *HACluster) RetryQueueStart(wg *sync.WaitGroup) {
+	wg.Add(1)
+	go hac.startRetryQueueGo(wg)
+}
+
+func (hac *HACluster) startRetryQueueGo(wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	log.Infof("Beginning Retry Queue process each %s ", hac.CheckInterval.String())
+
+	t := time.NewTicker(hac.CheckInterval)
+	for {
+		for _, ri := range hac.RetryQueue.due() {
+			log.Infof("RETRYQUEUE: retrying %s", &ri)
+			ctx, done := startJob(fmt.Sprintf("retry %s", &ri))
+			err := hac.retryQueued(ctx, &ri)
+			// cancelled retries are kept in queue as they were
+			if ctx.Err() == nil {
+				hac.RetryQueue.done(ri.ID, err)
+			}
+			done()
+		}
+	LOOP:
+		for {
+			select {
+			case <-t.C:
+				break LOOP
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"sync"
+)
+
+// schedTask is a copy task waiting to be run by the scheduler
+type schedTask struct {
+	src      string
+	dst      string
+	priority int64
+	seq      int64
+	run      func()
+}
+
+// Scheduler runs the copy tasks of all the running syncs with a fixed number
+// of workers, bounding the tasks running at the same time on each source and
+// destination node. Pending tasks with lower priority value run first, and
+// tasks with the same priority run in submission order.
+type Scheduler struct {
+	Workers   int
+	MaxPerSrc int
+	MaxPerDst int
+	mutex     sync.Mutex
+	cond      *sync.Cond
+	seq       int64
+	pending   []*schedTask
+	srcCount  map[string]int
+	dstCount  map[string]int
+}
+
+var (
+	scheduler     *Scheduler
+	schedulerOnce sync.Once
+)
+
+// getScheduler returns the scheduler shared by all syncs, created on first use
+func getScheduler() *Scheduler {
+	schedulerOnce.Do(func() {
+		scheduler = NewScheduler(MainConfig.General.NumWorkers, MainConfig.General.MaxWorkersPerSource, MainConfig.General.MaxWorkersPerDest)
+	})
+	return scheduler
+}
+
+// NewScheduler creates a scheduler and starts its workers, maxPerSrc and maxPerDst
+// lower than 1 or greater than workers are not limited further than workers
+func NewScheduler(workers int, maxPerSrc int, maxPerDst int) *Scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	if maxPerSrc < 1 || maxPerSrc > workers {
+		maxPerSrc = workers
+	}
+	if maxPerDst < 1 || maxPerDst > workers {
+		maxPerDst = workers
+	}
+	s := &Scheduler{
+		Workers:   workers,
+		MaxPerSrc: maxPerSrc,
+		MaxPerDst: maxPerDst,
+		srcCount:  make(map[string]int),
+		dstCount:  make(map[string]int),
+	}
+	s.cond = sync.NewCond(&s.mutex)
+	log.Infof("SCHEDULER: starting %d workers (max %d per source, %d per destination)", workers, maxPerSrc, maxPerDst)
+	for i := 0; i < workers; i++ {
+		go s.worker()
+	}
+	return s
+}
+
+// Submit queues run to copy data from src to dst node with priority
+func (s *Scheduler) Submit(src string, dst string, priority int64, run func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.seq++
+	s.pending = append(s.pending, &schedTask{src: src, dst: dst, priority: priority, seq: s.seq, run: run})
+	s.cond.Signal()
+}
+
+// next returns the pending task with lower priority whose nodes are not
+// at its limits, or -1 if none, s.mutex should be locked by the caller
+func (s *Scheduler) next() int {
+	n := -1
+	for i, t := range s.pending {
+		if s.srcCount[t.src] >= s.MaxPerSrc || s.dstCount[t.dst] >= s.MaxPerDst {
+			continue
+		}
+		if n < 0 || t.priority < s.pending[n].priority || (t.priority == s.pending[n].priority && t.seq < s.pending[n].seq) {
+			n = i
+		}
+	}
+	return n
+}
+
+func (s *Scheduler) worker() {
+	s.mutex.Lock()
+	for {
+		n := s.next()
+		if n < 0 {
+			s.cond.Wait()
+			continue
+		}
+		t := s.pending[n]
+		s.pending = append(s.pending[:n], s.pending[n+1:]...)
+		s.srcCount[t.src]++
+		s.dstCount[t.dst]++
+		s.mutex.Unlock()
+
+		t.run()
+
+		s.mutex.Lock()
+		s.srcCount[t.src]--
+		s.dstCount[t.dst]--
+		// a task of the freed nodes could be waiting
+		s.cond.Broadcast()
+	}
+}
@@ -1,13 +1,36 @@
 package agent
 
 import (
+	"context"
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/gammazero/workerpool"
+	"github.com/influxdata/influxdb1-client/v2"
 )
 
+// Kind of errors on copy a measurement chunk
+const (
+	ChunkErrorRead  = "read"
+	ChunkErrorWrite = "write"
+)
+
+// ChunkError is a measurement that could not be copied in a chunk
+type ChunkError struct {
+	Measurement string
+	// Kind is ChunkErrorRead or ChunkErrorWrite
+	Kind string
+	Err  string
+}
+
+func (ce *ChunkError) String() string {
+	return fmt.Sprintf("%s error: %s", ce.Kind, ce.Err)
+}
+
+// ChunkReport is the result of copy a chunk in the [TimeStart,TimeEnd) nanosecond
+// period, Errors has the error text of each measurement in FailedMeas, only the
+// failed measurements are retried
 type ChunkReport struct {
 	Num             int64
 	Total           int64
@@ -17,6 +40,7 @@ type ChunkReport struct {
 	ReadErrors      uint64
 	WriteErrors     uint64
 	Errors          []string
+	FailedMeas      []*ChunkError
 	ProcessedPoints int64
 	TimeTaken       time.Duration
 }
@@ -28,9 +52,9 @@ func (cr *ChunkReport) format() string {
 		cr.Total,
 		percent,
 		cr.TimeStart,
-		time.Unix(cr.TimeStart, 0).String(),
+		time.Unix(0, cr.TimeStart).String(),
 		cr.TimeEnd,
-		time.Unix(cr.TimeEnd, 0).String(),
+		time.Unix(0, cr.TimeEnd).String(),
 		cr.ProcessedPoints,
 		cr.TimeTaken.String(),
 		cr.ReadErrors,
@@ -65,6 +89,8 @@ type SyncReport struct {
 	End          time.Time
 	ChunkReport  []*ChunkReport
 	BadChunks    []*ChunkReport
+	// Cancelled is true if the sync was cancelled before copy all the chunks
+	Cancelled bool
 }
 
 func (sr *SyncReport) Log(prefix string) {
@@ -82,6 +108,27 @@ func (sr *SyncReport) Log(prefix string) {
 		len(sr.BadChunks))
 }
 
+// LogBadChunks logs the time ranges of each measurement that could not be copied
+func (sr *SyncReport) LogBadChunks() {
+	for _, bc := range sr.BadChunks {
+		for _, ce := range bc.FailedMeas {
+			log.Errorf("Data not copied from %s[%s|%s|%s] to %s[%s|%s] FROM [%d][%s] TO [%d][%s] : %s",
+				sr.SrcSrv,
+				sr.SrcDB,
+				sr.SrcRP,
+				ce.Measurement,
+				sr.DstSrv,
+				sr.DstDB,
+				sr.DstRP,
+				bc.TimeStart,
+				time.Unix(0, bc.TimeStart).String(),
+				bc.TimeEnd,
+				time.Unix(0, bc.TimeEnd).String(),
+				ce)
+		}
+	}
+}
+
 func (sr *SyncReport) RWErrors() (uint64, uint64, uint64) {
 	var readErrors, writeErrors uint64
 	for _, b := range sr.BadChunks {
@@ -92,7 +139,251 @@ func (sr *SyncReport) RWErrors() (uint64, uint64, uint64) {
 	return readErrors, writeErrors, readErrors + writeErrors
 }
 
-func Sync(src *InfluxMonitor, dst *InfluxMonitor, sdb string, ddb string, srp *RetPol, drp *RetPol, sEpoch time.Time, eEpoch time.Time, dbschema *InfluxSchDb, chunk time.Duration, maxret time.Duration) *SyncReport {
+// Chunk copy orders
+const (
+	CopyOrderNewestFirst = "newest-first"
+	CopyOrderOldestFirst = "oldest-first"
+)
+
+// oldestFirst returns true if chunks should be copied in chronological order
+func oldestFirst() bool {
+	return MainConfig.General.CopyOrder == CopyOrderOldestFirst
+}
+
+// reverseRanges reverses in place the order of the time ranges r
+func reverseRanges(r [][2]int64) {
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+}
+
+// measChunks returns the time ranges to copy measurement meas in the [start,end) nanosecond chunk.
+// On adaptive mode (data-chunk-target-points > 0) the chunk is split in smaller ones
+// of up to data-chunk-target-points, probing the point density with count(*) grouped
+// by data-chunk-min-duration, empty measurements return no ranges.
+func measChunks(c client.Client, sdb string, rp string, meas string, start int64, end int64) [][2]int64 {
+	target := MainConfig.General.DataChunkTargetPoints
+	// downsampled chunks are not split to keep the group by time intervals whole
+	if target <= 0 || downsample != nil {
+		return [][2]int64{{start, end}}
+	}
+	counts, err := CountPointsByTime(c, sdb, rp, meas, time.Unix(0, start), time.Unix(0, end), MainConfig.General.DataChunkMinDuration)
+	if err != nil {
+		log.Warnf("Error on probe point density for %s|%s|%s , copying the whole chunk: %s", sdb, rp, meas, err)
+		return [][2]int64{{start, end}}
+	}
+
+	chunks := [][2]int64{}
+	cur := start
+	var points, total int64
+	for _, pc := range counts {
+		t := pc.Time.UnixNano()
+		if points > 0 && points+pc.Count > target && t > cur {
+			chunks = append(chunks, [2]int64{cur, t})
+			cur = t
+			points = 0
+		}
+		points += pc.Count
+		total += pc.Count
+	}
+	if total == 0 {
+		log.Debugf("No points for %s|%s|%s from %d to %d", sdb, rp, meas, start, end)
+		return nil
+	}
+	chunks = append(chunks, [2]int64{cur, end})
+	log.Debugf("Split %s|%s|%s from %d to %d with %d points in %d chunks", sdb, rp, meas, start, end, total, len(chunks))
+	return chunks
+}
+
+// chunkJob is a chunk being copied by the scheduler
+type chunkJob struct {
+	wg          sync.WaitGroup
+	startOnce   sync.Once
+	started     time.Time
+	start       int64
+	end         int64
+	totalpoints int64
+	readErrors  uint64
+	writeErrors uint64
+	failedMutex sync.Mutex
+	failedMeas  []*ChunkError
+}
+
+func newChunkJob(start int64, end int64) *chunkJob {
+	return &chunkJob{
+		started:    time.Now(),
+		start:      start,
+		end:        end,
+		failedMeas: []*ChunkError{},
+	}
+}
+
+// copyMeasurement copies the m measurement data in the [start,end) nanosecond chunk
+// from src to dst, if cp is not nil it is skipped if already done
+func (cj *chunkJob) copyMeasurement(ctx context.Context, src *InfluxMonitor, dst *InfluxMonitor, sdb string, ddb string, srp *RetPol, drp *RetPol, m string, sch *MeasurementSch, Report *SyncReport, cp *Checkpoint) {
+	start, end := cj.start, cj.end
+	if ctx.Err() != nil {
+		// cancelled measurements are not done nor failed, they will be copied on resume
+		return
+	}
+	cj.startOnce.Do(func() { cj.started = time.Now() })
+	var key string
+	if cp != nil {
+		key = measKey(Report, m)
+		if cp.IsDone(key, start, end) {
+			log.Debugf("skipping already done Database %s Measurement %s from %d to %d", sdb, m, start, end)
+			return
+		}
+	}
+	log.Tracef("Processing measurement %s with schema #%+v", m, sch)
+	log.Debugf("processing Database %s Measurement %s from %d to %d", sdb, m, start, end)
+	var werr, rerr error
+	var np int64
+	chunks := measChunks(src.cli, sdb, srp.Name, m, start, end)
+	if !oldestFirst() {
+		reverseRanges(chunks)
+	}
+CHUNKS:
+	for _, ch := range chunks {
+		// measurements with field type conflicts are read by shard group
+		frs := sch.fieldRanges(ch[0], ch[1])
+		if !oldestFirst() {
+			for l, r := 0, len(frs)-1; l < r; l, r = l+1, r-1 {
+				frs[l], frs[r] = frs[r], frs[l]
+			}
+		}
+		for _, fr := range frs {
+			getvalues := fmt.Sprintf("select * from  \"%v\" where time >= %d and time < %d%s group by *", m, fr.start, fr.end, whereClause())
+			fieldmap := fr.fields
+			if downsample != nil {
+				if getvalues, fieldmap = downsample.query(m, fr.fields, fr.start, fr.end); len(getvalues) == 0 {
+					continue
+				}
+			}
+			var n int64
+			n, rerr = ReadDB(ctx, src.cli, sdb, srp.Name, ddb, drp.Name, getvalues, fieldmap, func(bp client.BatchPoints) error {
+				werr = WriteDB(ctx, dst.cli, bp)
+				return werr
+			})
+			np += n
+			if werr != nil || rerr != nil {
+				break CHUNKS
+			}
+		}
+	}
+	atomic.AddInt64(&cj.totalpoints, np)
+	if ctx.Err() != nil {
+		log.Warnf("cancelled copy of DB %s | Measurement %s from %d to %d", sdb, m, start, end)
+		return
+	}
+	if werr != nil {
+		atomic.AddUint64(&cj.writeErrors, 1)
+		log.Errorf("error in write DB %s | Measurement %s | ERR: %s", ddb, m, werr)
+		cj.failedMutex.Lock()
+		cj.failedMeas = append(cj.failedMeas, &ChunkError{Measurement: m, Kind: ChunkErrorWrite, Err: werr.Error()})
+		cj.failedMutex.Unlock()
+		return
+		//return err
+	}
+	if rerr != nil {
+		atomic.AddUint64(&cj.readErrors, 1)
+		log.Errorf("error in read DB %s | Measurement %s | ERR: %s", sdb, m, rerr)
+		cj.failedMutex.Lock()
+		cj.failedMeas = append(cj.failedMeas, &ChunkError{Measurement: m, Kind: ChunkErrorRead, Err: rerr.Error()})
+		cj.failedMutex.Unlock()
+		return
+		//return err
+	}
+	//totalpoints += np
+	log.Debugf("processed %d points", np)
+	if cp != nil {
+		cp.SetDone(key, start, end)
+	}
+}
+
+// startChunk submits to the scheduler the copy of each measurement data in the
+// [start,end) nanosecond chunk from src to dst, if cp is not nil measurements
+// already done are skipped. Tasks with lower priority value run first.
+func startChunk(ctx context.Context, src *InfluxMonitor, dst *InfluxMonitor, sdb string, ddb string, srp *RetPol, drp *RetPol, measurements map[string]*MeasurementSch, start int64, end int64, Report *SyncReport, cp *Checkpoint, priority int64) *chunkJob {
+	cj := newChunkJob(start, end)
+	log.Debugf("Detected %d measurements on %s|%s", len(measurements), sdb, srp.Name)
+	sched := getScheduler()
+	for m, sch := range measurements {
+		m := m
+		sch := sch
+
+		cj.wg.Add(1)
+		//add to the scheduler
+		sched.Submit(src.cfg.Name, dst.cfg.Name, priority, func() {
+			defer cj.wg.Done()
+			cj.copyMeasurement(ctx, src, dst, sdb, ddb, srp, drp, m, sch, Report, cp)
+		})
+	}
+	return cj
+}
+
+// wait waits until all the chunk measurements are copied, saves the
+// progress if cp is not nil and returns the chunk report
+func (cj *chunkJob) wait(cp *Checkpoint) *ChunkReport {
+	cj.wg.Wait()
+	if cp != nil {
+		if err := cp.Save(); err != nil {
+			log.Errorf("Error on save checkpoint: %s", err)
+		}
+	}
+	chunkElapsed := time.Since(cj.started)
+	errors := make([]string, 0, len(cj.failedMeas))
+	for _, ce := range cj.failedMeas {
+		errors = append(errors, fmt.Sprintf("%s: %s", ce.Measurement, ce))
+	}
+	return &ChunkReport{
+		TimeExec:        time.Now(),
+		TimeStart:       cj.start,
+		TimeEnd:         cj.end,
+		ReadErrors:      cj.readErrors,
+		WriteErrors:     cj.writeErrors,
+		Errors:          errors,
+		FailedMeas:      cj.failedMeas,
+		ProcessedPoints: cj.totalpoints,
+		TimeTaken:       chunkElapsed,
+	}
+}
+
+// syncChunk copies the measurements data in the [start,end) nanosecond chunk from src to dst
+// and waits until done, if cp is not nil measurements already done are skipped and the
+// progress is saved
+func syncChunk(ctx context.Context, src *InfluxMonitor, dst *InfluxMonitor, sdb string, ddb string, srp *RetPol, drp *RetPol, measurements map[string]*MeasurementSch, start int64, end int64, Report *SyncReport, cp *Checkpoint) *ChunkReport {
+	return startChunk(ctx, src, dst, sdb, ddb, srp, drp, measurements, start, end, Report, cp, 0).wait(cp)
+}
+
+// chunkBounds returns the [start,end) nanosecond chunks tiling the [sEpoch,eEpoch) period back
+// from eEpoch with no gaps or overlaps, newest first. The oldest chunk is truncated to begin
+// at sEpoch and no more than maxret/chunk+1 chunks are returned.
+func chunkBounds(sEpoch int64, eEpoch int64, chunk int64, maxret int64) [][2]int64 {
+	if eEpoch <= sEpoch || chunk <= 0 {
+		return [][2]int64{}
+	}
+	length := (eEpoch - sEpoch + chunk - 1) / chunk
+	first := sEpoch
+	if max := maxret/chunk + 1; length > max {
+		length = max
+		first = eEpoch - length*chunk
+	}
+	bounds := make([][2]int64, 0, length)
+	for n := int64(0); n < length; n++ {
+		end := eEpoch - n*chunk
+		start := end - chunk
+		if start < first {
+			start = first
+		}
+		bounds = append(bounds, [2]int64{start, end})
+	}
+	return bounds
+}
+
+// Sync copies data from src to dst in chunks, if cp is not nil chunks already
+// done are skipped and the progress is saved after each chunk
+func Sync(ctx context.Context, src *InfluxMonitor, dst *InfluxMonitor, sdb string, ddb string, srp *RetPol, drp *RetPol, sEpoch time.Time, eEpoch time.Time, dbschema *InfluxSchDb, chunk time.Duration, maxret time.Duration, cp *Checkpoint) *SyncReport {
 
 	if dbschema == nil {
 		err := fmt.Errorf("DBSChema for DB %s is null", sdb)
@@ -111,122 +402,181 @@ func Sync(src *InfluxMonitor, dst *InfluxMonitor, sdb string, ddb string, srp *R
 		End:    eEpoch,
 	}
 
-	var hLength int64
-	var MaxLength int64
-	var chunkSecond int64
+	if downsample != nil {
+		// chunks should begin and end on group by time interval limits
+		sEpoch = time.Unix(0, downsample.align(sEpoch.UnixNano()))
+		eEpoch = time.Unix(0, downsample.align(eEpoch.UnixNano()))
+		chunk = downsample.alignDuration(chunk)
+	}
 
 	duration := eEpoch.Sub(sEpoch)
 
-	hLength = int64(duration/chunk) + 1
-
-	MaxLength = int64(maxret/chunk) + 1
-
-	if hLength > MaxLength {
-		hLength = MaxLength
+	bounds := chunkBounds(sEpoch.UnixNano(), eEpoch.UnixNano(), int64(chunk), int64(maxret))
+	// chunks are numbered from eEpoch back, sync from newer to older
+	// data or from older to newer if copy-order is oldest-first
+	if oldestFirst() {
+		reverseRanges(bounds)
 	}
+	hLength := int64(len(bounds))
 
-	chunkSecond = int64(chunk.Seconds())
-
-	chuckReport := make([]*ChunkReport, hLength)
+	chuckReport := make([]*ChunkReport, 0, hLength)
 	badChunkReport := make([]*ChunkReport, 0)
 
 	log.Debugf("SYNC-DB-RP[%s|%s] From:%s To:%s | Duration: %s || #chunks: %d  | chunk Duration %s ", sdb, srp.Name, sEpoch.String(), eEpoch.String(), duration.String(), hLength, chunk.String())
-	log.Tracef("SYNC-DB-RP Schema: %s  ", srp)
+	log.Tracef("SYNC-DB-RP Schema: %+v  ", srp)
 
 	var i int64
 	var dbpoints int64
 	dbs := time.Now()
 
+	// each measurement is copied chunk after chunk by the scheduler with up to num-workers
+	// of its chunks running, so a slow measurement does not stall the other ones. On
+	// oldest-first only one chunk runs at a time to write the data in chronological order
+	inflight := MainConfig.General.NumWorkers
+	if inflight < 2 {
+		inflight = 2
+	}
+	if oldestFirst() {
+		inflight = 1
+	}
+	jobs := make([]*chunkJob, hLength)
 	for i = 0; i < hLength; i++ {
-		wp := workerpool.New(MainConfig.General.NumWorkers)
-		defer wp.Stop()
-		chs := time.Now()
-		//sync from newer to older data
-		endsec := eEpoch.Unix() - (i * chunkSecond)
-		startsec := eEpoch.Unix() - ((i + 1) * chunkSecond)
-		var totalpoints int64
-		totalpoints = 0
-		log.Debugf("Detected %d measurements on %s|%s", len(srp.Measurements), sdb, srp.Name)
-		//--------
-		var readErrors uint64
-		var writeErrors uint64
-		//--------
-		for m, sch := range srp.Measurements {
-			m := m
-			sch := sch
-
-			//add to the worker pool
-			wp.Submit(func() {
-				log.Tracef("Processing measurement %s with schema #%+v", m, sch)
-				log.Debugf("processing Database %s Measurement %s from %d to %d", sdb, m, startsec, endsec)
-				getvalues := fmt.Sprintf("select * from  \"%v\" where time  > %vs and time < %vs group by *", m, startsec, endsec)
-				batchpoints, np, rerr := ReadDB(src.cli, sdb, srp.Name, ddb, drp.Name, getvalues, sch.Fields)
-				if rerr != nil {
-					atomic.AddUint64(&readErrors, 1)
-					log.Errorf("error in read DB %s | Measurement %s | ERR: %s", sdb, m, rerr)
-					return
-					//return err
-				}
-				atomic.AddInt64(&totalpoints, np)
-				//totalpoints += np
-				log.Debugf("processed %d points", np)
-				werr := WriteDB(dst.cli, batchpoints)
-				if werr != nil {
-					atomic.AddUint64(&writeErrors, 1)
-					log.Errorf("error in write DB %s | Measurement %s | ERR: %s", ddb, m, werr)
-					return
-					//return err
+		jobs[i] = newChunkJob(bounds[i][0], bounds[i][1])
+		jobs[i].wg.Add(len(srp.Measurements))
+	}
+	sched := getScheduler()
+	for m, sch := range srp.Measurements {
+		go func(m string, sch *MeasurementSch) {
+			running := make(chan struct{}, inflight)
+			for i, cj := range jobs {
+				if ctx.Err() != nil {
+					cj.wg.Done()
+					continue
 				}
-			})
-			//write datas of every hour
-		}
-		wp.StopWait()
-		chunkElapsed := time.Since(chs)
-		dbpoints += totalpoints
-		chrep := &ChunkReport{
-			Num:             i + 1,
-			Total:           hLength,
-			TimeExec:        time.Now(),
-			TimeStart:       startsec,
-			TimeEnd:         endsec,
-			ReadErrors:      readErrors,
-			WriteErrors:     writeErrors,
-			ProcessedPoints: totalpoints,
-			TimeTaken:       chunkElapsed,
+				running <- struct{}{}
+				cj := cj
+				sched.Submit(src.cfg.Name, dst.cfg.Name, int64(i), func() {
+					defer func() {
+						<-running
+						cj.wg.Done()
+					}()
+					cj.copyMeasurement(ctx, src, dst, sdb, ddb, srp, drp, m, sch, Report, cp)
+				})
+			}
+		}(m, sch)
+	}
+
+	// chunk reports are collected in order as all their measurements are done
+	for i = 0; i < hLength; i++ {
+		chrep := jobs[i].wait(cp)
+		jobs[i] = nil
+		if ctx.Err() != nil {
+			Report.Cancelled = true
+			continue
 		}
+		chrep.Num = i + 1
+		chrep.Total = hLength
+		dbpoints += chrep.ProcessedPoints
 
 		chrep.Log("Processed Chunk")
 		chuckReport = append(chuckReport, chrep)
-		if readErrors+writeErrors > 0 {
+		if chrep.ReadErrors+chrep.WriteErrors > 0 {
 			badChunkReport = append(badChunkReport, chrep)
 		}
-
 	}
 
 	Report.TotalElapsed = time.Since(dbs)
 	Report.TotalPoints = dbpoints
 	Report.ChunkReport = chuckReport
 	Report.BadChunks = badChunkReport
-	Report.Log("Processed DB")
+	if Report.Cancelled {
+		Report.Log("Cancelled DB")
+	} else {
+		Report.Log("Processed DB")
+	}
 
 	return Report
 }
 
-func SyncDBRP(src *InfluxMonitor, dst *InfluxMonitor, sdb string, ddb string, srp *RetPol, drp *RetPol, sEpoch time.Time, eEpoch time.Time, dbschema *InfluxSchDb, chunk time.Duration, maxret time.Duration) *SyncReport {
+// bisectChunk copies again the failed measurements of the bc bad chunk splitting its
+// time range in halves recursively until they are copied or the range is not greater
+// than min nanoseconds, it returns the minimal bad chunks that could not be copied.
+// If ctx is cancelled bc is returned as not copied and the report is marked as cancelled.
+func bisectChunk(ctx context.Context, src *InfluxMonitor, dst *InfluxMonitor, sdb string, ddb string, srp *RetPol, drp *RetPol, bc *ChunkReport, min int64, report *SyncReport) []*ChunkReport {
+	if bc.TimeEnd-bc.TimeStart <= min {
+		return []*ChunkReport{bc}
+	}
+	measurements := make(map[string]*MeasurementSch)
+	for _, ce := range bc.FailedMeas {
+		if sch, ok := srp.Measurements[ce.Measurement]; ok {
+			measurements[ce.Measurement] = sch
+		}
+	}
+	if len(measurements) == 0 {
+		return []*ChunkReport{bc}
+	}
+
+	mid := bc.TimeStart + (bc.TimeEnd-bc.TimeStart)/2
+	if downsample != nil {
+		if mid = downsample.align(mid); mid <= bc.TimeStart {
+			return []*ChunkReport{bc}
+		}
+	}
+	badChunks := []*ChunkReport{}
+	halves := [][2]int64{{mid, bc.TimeEnd}, {bc.TimeStart, mid}}
+	if oldestFirst() {
+		halves[0], halves[1] = halves[1], halves[0]
+	}
+	for i, r := range halves {
+		if ctx.Err() != nil {
+			report.Cancelled = true
+			return []*ChunkReport{bc}
+		}
+		chrep := syncChunk(ctx, src, dst, sdb, ddb, srp, drp, measurements, r[0], r[1], report, nil)
+		if ctx.Err() != nil {
+			// cancelled measurements record no errors, the whole chunk is kept as bad
+			report.Cancelled = true
+			return []*ChunkReport{bc}
+		}
+		chrep.Num = int64(i + 1)
+		chrep.Total = 2
+		report.TotalPoints += chrep.ProcessedPoints
+		if chrep.ReadErrors+chrep.WriteErrors == 0 {
+			chrep.Log("Recovered Bad Chunk")
+			continue
+		}
+		chrep.Warn("Bisecting Bad Chunk")
+		badChunks = append(badChunks, bisectChunk(ctx, src, dst, sdb, ddb, srp, drp, chrep, min, report)...)
+	}
+	return badChunks
+}
+
+// bisectMinWindow returns the bisect-min-window in nanoseconds, not lower than one second
+func bisectMinWindow() int64 {
+	if MainConfig.General.BisectMinWindow < time.Second {
+		return int64(time.Second)
+	}
+	return int64(MainConfig.General.BisectMinWindow)
+}
+
+// SyncDBRP copies data from src to dst as Sync does, the failing chunks are
+// bisected until they are copied or reach the bisect-min-window duration
+func SyncDBRP(ctx context.Context, src *InfluxMonitor, dst *InfluxMonitor, sdb string, ddb string, srp *RetPol, drp *RetPol, sEpoch time.Time, eEpoch time.Time, dbschema *InfluxSchDb, chunk time.Duration, maxret time.Duration, cp *Checkpoint) *SyncReport {
 
-	report := Sync(src, dst, sdb, ddb, srp, drp, sEpoch, eEpoch, dbschema, chunk, maxret)
-	if len(report.BadChunks) > 0 {
+	report := Sync(ctx, src, dst, sdb, ddb, srp, drp, sEpoch, eEpoch, dbschema, chunk, maxret, cp)
+	if report == nil {
+		return nil
+	}
+	if len(report.BadChunks) > 0 && !report.Cancelled {
 		log.Warnf("Initializing Recovery for %d chunks", len(report.BadChunks))
+		min := bisectMinWindow()
 		newBadChunks := make([]*ChunkReport, 0)
 		for _, bc := range report.BadChunks {
 			bc.Warn("Recovery for Bad Chunk")
-			start := time.Unix(bc.TimeStart, 0)
-			end := time.Unix(bc.TimeEnd, 0)
-
-			recoveryrep := Sync(src, dst, sdb, ddb, srp, drp, start, end, dbschema, chunk/10, maxret)
-			newBadChunks = append(newBadChunks, recoveryrep.BadChunks...)
+			newBadChunks = append(newBadChunks, bisectChunk(ctx, src, dst, sdb, ddb, srp, drp, bc, min, report)...)
 		}
 		report.BadChunks = newBadChunks
+		report.LogBadChunks()
 	}
 	return report
 }
@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"testing"
+	"time"
+)
+
+// covering returns the number of chunks in bounds containing the t timestamp
+func covering(bounds [][2]int64, t int64) int {
+	n := 0
+	for _, b := range bounds {
+		if t >= b[0] && t < b[1] {
+			n++
+		}
+	}
+	return n
+}
+
+func TestChunkBoundsTiling(t *testing.T) {
+	s := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	e := s + int64(10*time.Hour)
+	chunk := int64(time.Hour)
+	bounds := chunkBounds(s, e, chunk, int64(365*24*time.Hour))
+	if len(bounds) != 10 {
+		t.Fatalf("got %d chunks, want 10", len(bounds))
+	}
+	if bounds[0][1] != e {
+		t.Errorf("newest chunk ends at %d, want %d", bounds[0][1], e)
+	}
+	for k := 1; k < len(bounds); k++ {
+		if bounds[k][1] != bounds[k-1][0] {
+			t.Errorf("chunk %d ends at %d, chunk %d begins at %d", k, bounds[k][1], k-1, bounds[k-1][0])
+		}
+	}
+	// points on chunk edges are copied exactly once
+	for _, b := range bounds {
+		if n := covering(bounds, b[0]); n != 1 {
+			t.Errorf("point on edge %d is in %d chunks", b[0], n)
+		}
+	}
+	if n := covering(bounds, e); n != 0 {
+		t.Errorf("point on period end %d is in %d chunks, want 0", e, n)
+	}
+	if n := covering(bounds, e-1); n != 1 {
+		t.Errorf("last nanosecond is in %d chunks, want 1", n)
+	}
+}
+
+func TestChunkBoundsOldestTruncated(t *testing.T) {
+	s := int64(1000)
+	e := s + 25
+	bounds := chunkBounds(s, e, 10, 1000)
+	want := [][2]int64{{1015, 1025}, {1005, 1015}, {1000, 1005}}
+	if len(bounds) != len(want) {
+		t.Fatalf("got %v, want %v", bounds, want)
+	}
+	for k := range want {
+		if bounds[k] != want[k] {
+			t.Errorf("chunk %d is %v, want %v", k, bounds[k], want[k])
+		}
+	}
+}
+
+func TestChunkBoundsMaxRetention(t *testing.T) {
+	e := int64(100000)
+	// maxret/chunk+1 = 4 chunks, the oldest one is not truncated
+	bounds := chunkBounds(0, e, 10, 35)
+	if len(bounds) != 4 {
+		t.Fatalf("got %d chunks, want 4", len(bounds))
+	}
+	if oldest := bounds[len(bounds)-1]; oldest != [2]int64{e - 40, e - 30} {
+		t.Errorf("oldest chunk is %v, want %v", oldest, [2]int64{e - 40, e - 30})
+	}
+}
+
+func TestChunkBoundsEmpty(t *testing.T) {
+	if bounds := chunkBounds(10, 10, 5, 100); len(bounds) != 0 {
+		t.Errorf("got %v for an empty period", bounds)
+	}
+	if bounds := chunkBounds(20, 10, 5, 100); len(bounds) != 0 {
+		t.Errorf("got %v for a negative period", bounds)
+	}
+}
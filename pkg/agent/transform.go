@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/toni-moreno/syncflux/pkg/config"
+)
+
+// transform is a compiled transform rule
+type transform struct {
+	meas         *regexp.Regexp
+	rename       string
+	renameTags   map[string]string
+	dropTags     map[string]bool
+	addTags      map[string]string
+	renameFields map[string]string
+	dropFields   map[string]bool
+}
+
+// measTransform are the rules applied to the points of a source measurement and its new name
+type measTransform struct {
+	name   string
+	rules  []*transform
+	fields bool
+}
+
+var (
+	transforms []*transform
+	// measTransforms caches the measTransform of each source measurement
+	measTransforms sync.Map
+)
+
+// parsePairs parses a list of "key=value" strings
+func parsePairs(pairs []string) (map[string]string, error) {
+	m := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 || len(kv[1]) == 0 {
+			return nil, fmt.Errorf("invalid pair %q, should be key=value", p)
+		}
+		m[kv[0]] = kv[1]
+	}
+	return m, nil
+}
+
+func toSet(list []string) map[string]bool {
+	s := make(map[string]bool, len(list))
+	for _, k := range list {
+		s[k] = true
+	}
+	return s
+}
+
+// SetTransforms compiles the rules applied to the copied points. Rules are applied in
+// order, each one to the measurements whose name (renamed by the previous rules) matches it.
+func SetTransforms(rules []*config.TransformRule) error {
+	list := make([]*transform, 0, len(rules))
+	for i, r := range rules {
+		re, err := regexp.Compile(r.Measurement)
+		if err != nil {
+			return fmt.Errorf("transform rule %d: invalid measurement regex %s: %s", i+1, r.Measurement, err)
+		}
+		t := &transform{
+			meas:       re,
+			rename:     r.Rename,
+			dropTags:   toSet(r.DropTags),
+			dropFields: toSet(r.DropFields),
+		}
+		if t.renameTags, err = parsePairs(r.RenameTags); err != nil {
+			return fmt.Errorf("transform rule %d: rename-tags: %s", i+1, err)
+		}
+		if t.addTags, err = parsePairs(r.AddTags); err != nil {
+			return fmt.Errorf("transform rule %d: add-tags: %s", i+1, err)
+		}
+		if t.renameFields, err = parsePairs(r.RenameFields); err != nil {
+			return fmt.Errorf("transform rule %d: rename-fields: %s", i+1, err)
+		}
+		log.Infof("Transform rule %d: measurement [%s] rename [%s] rename-tags %v drop-tags %v add-tags %v rename-fields %v drop-fields %v",
+			i+1, r.Measurement, r.Rename, r.RenameTags, r.DropTags, r.AddTags, r.RenameFields, r.DropFields)
+		list = append(list, t)
+	}
+	transforms = list
+	measTransforms = sync.Map{}
+	return nil
+}
+
+// getMeasTransform returns the rules to apply to the points of the meas source measurement
+func getMeasTransform(meas string) *measTransform {
+	if mt, ok := measTransforms.Load(meas); ok {
+		return mt.(*measTransform)
+	}
+	mt := &measTransform{name: meas}
+	for _, t := range transforms {
+		if !t.meas.MatchString(mt.name) {
+			continue
+		}
+		mt.rules = append(mt.rules, t)
+		if len(t.rename) > 0 {
+			// the matched part is replaced as sed does, $1 expands to the first submatch
+			mt.name = t.meas.ReplaceAllString(mt.name, t.rename)
+		}
+		if len(t.renameFields) > 0 || len(t.dropFields) > 0 {
+			mt.fields = true
+		}
+	}
+	measTransforms.Store(meas, mt)
+	return mt
+}
+
+// transformMeasurement returns the name of the meas source measurement on the destination
+func transformMeasurement(meas string) string {
+	return getMeasTransform(meas).name
+}
+
+// transformsFields returns true if the transform rules rename or drop fields of meas
+func transformsFields(meas string) bool {
+	return getMeasTransform(meas).fields
+}
+
+// transformPoint applies the transform rules to a point of the meas measurement, tags is not
+// modified as it is shared by all the points of the serie, a copy is returned if needed
+func transformPoint(meas string, tags map[string]string, fields map[string]interface{}) (string, map[string]string, map[string]interface{}) {
+	mt := getMeasTransform(meas)
+	if len(mt.rules) == 0 {
+		return meas, tags, fields
+	}
+	newtags := make(map[string]string, len(tags))
+	for k, v := range tags {
+		newtags[k] = v
+	}
+	for _, t := range mt.rules {
+		for k := range t.dropTags {
+			delete(newtags, k)
+		}
+		for from, to := range t.renameTags {
+			if v, ok := newtags[from]; ok {
+				delete(newtags, from)
+				newtags[to] = v
+			}
+		}
+		for k, v := range t.addTags {
+			newtags[k] = v
+		}
+		for k := range t.dropFields {
+			delete(fields, k)
+		}
+		for from, to := range t.renameFields {
+			if v, ok := fields[from]; ok {
+				delete(fields, from)
+				fields[to] = v
+			}
+		}
+	}
+	return mt.name, newtags, fields
+}
@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+)
+
+// DataMismatch is a measurement with different number of points between
+// the source and the destination nodes in a time window
+type DataMismatch struct {
+	DB          string
+	RP          string
+	Measurement string
+	Start       time.Time
+	End         time.Time
+	SrcPoints   int64
+	DstPoints   int64
+	Error       string
+}
+
+func (dm *DataMismatch) String() string {
+	if len(dm.Error) > 0 {
+		return fmt.Sprintf("[%s|%s|%s] FROM [ %s ] TO [ %s ] ERROR: %s", dm.DB, dm.RP, dm.Measurement, dm.Start.String(), dm.End.String(), dm.Error)
+	}
+	return fmt.Sprintf("[%s|%s|%s] FROM [ %s ] TO [ %s ] Points SRC %d DST %d", dm.DB, dm.RP, dm.Measurement, dm.Start.String(), dm.End.String(), dm.SrcPoints, dm.DstPoints)
+}
+
+// verifyData compares the number of points of each measurement in the [start,end) period
+// between src and dst and returns the measurements that differ
+func verifyData(src *InfluxMonitor, dst *InfluxMonitor, schema []*InfluxSchDb, start time.Time, end time.Time) []*DataMismatch {
+	mismatches := []*DataMismatch{}
+	for _, db := range schema {
+		for _, rp := range db.Rps {
+			drp := rp.Name
+			if rp.Def {
+				drp = db.NewDefRp
+			}
+			for m, sch := range rp.Measurements {
+				dm := &DataMismatch{DB: db.Name, RP: rp.Name, Measurement: m, Start: start, End: end}
+				var err error
+				dm.SrcPoints, err = CountPoints(src.cli, db.Name, rp.Name, m, start, end)
+				if err == nil {
+					dm.DstPoints, err = CountPoints(dst.cli, db.NewName, drp, sch.NewName, start, end)
+				}
+				if err != nil {
+					dm.Error = err.Error()
+				}
+				if dm.SrcPoints == dm.DstPoints && err == nil {
+					continue
+				}
+				log.Warnf("HACLUSTER: VERIFY [%s -> %s] mismatch %s", src.cfg.Name, dst.cfg.Name, dm)
+				mismatches = append(mismatches, dm)
+			}
+		}
+	}
+	return mismatches
+}
+
+// verifyMeasurement counts again the points of the dm measurement on src and dst
+// (with the same db and rp names on both nodes), returns true if they match
+func verifyMeasurement(src *InfluxMonitor, dst *InfluxMonitor, dm *DataMismatch) bool {
+	var err error
+	dm.Error = ""
+	dm.SrcPoints, err = CountPoints(src.cli, dm.DB, dm.RP, dm.Measurement, dm.Start, dm.End)
+	if err == nil {
+		dm.DstPoints, err = CountPoints(dst.cli, dm.DB, dm.RP, transformMeasurement(dm.Measurement), dm.Start, dm.End)
+	}
+	if err != nil {
+		dm.Error = err.Error()
+		return false
+	}
+	return dm.SrcPoints == dm.DstPoints
+}
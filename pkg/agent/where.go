@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/influxdata/influxdb1-client/v2"
+)
+
+// seriesWhere is the InfluxQL predicate filtering the series copied by the copy actions
+var seriesWhere string
+
+// SetWhere sets the InfluxQL predicate (f.e. host =~ /^web/) to filter the series copied,
+// the predicate is added to the time condition of the copy queries
+func SetWhere(where string) error {
+	where = strings.TrimSpace(where)
+	if err := checkPredicate(where); err != nil {
+		return fmt.Errorf("invalid where filter %s: %s", where, err)
+	}
+	if len(where) > 0 {
+		log.Infof("Filtering copied series with where %s", where)
+	}
+	seriesWhere = where
+	return nil
+}
+
+// checkPredicate verifies where is a single expression which can not escape the
+// parenthesis added by whereClause nor change the time condition of the copy queries
+func checkPredicate(where string) error {
+	var quote rune
+	var ident strings.Builder
+	escaped := false
+	depth := 0
+	prev := ' '
+	// word ends the unquoted identifier or keyword being read
+	word := func() error {
+		w := ident.String()
+		ident.Reset()
+		if strings.EqualFold(w, "time") {
+			return fmt.Errorf("time conditions are not allowed")
+		}
+		return nil
+	}
+	for _, r := range where {
+		switch {
+		case escaped:
+			escaped = false
+			if quote == '"' {
+				ident.WriteRune(r)
+			}
+			continue
+		case r == '\\':
+			escaped = true
+			continue
+		case quote != 0:
+			if r == quote {
+				quote = 0
+				if r == '"' {
+					if err := word(); err != nil {
+						return err
+					}
+				}
+			} else if quote == '"' {
+				ident.WriteRune(r)
+			}
+			continue
+		case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			ident.WriteRune(r)
+			continue
+		}
+		if err := word(); err != nil {
+			return err
+		}
+		switch {
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '/' && prev == '~':
+			// regex literal after =~ or !~
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("unbalanced parenthesis")
+			}
+		case r == ';':
+			return fmt.Errorf("only one predicate is allowed")
+		}
+		if !unicode.IsSpace(r) {
+			prev = r
+		}
+	}
+	if quote != 0 {
+		return fmt.Errorf("unterminated %c quote", quote)
+	}
+	if depth != 0 {
+		return fmt.Errorf("unbalanced parenthesis")
+	}
+	return word()
+}
+
+// whereClause returns the series filter condition to append to the time condition
+func whereClause() string {
+	if len(seriesWhere) == 0 {
+		return ""
+	}
+	return " and (" + seriesWhere + ")"
+}
+
+// checkWhere validates the series filter on c with the first measurement of
+// schema, the query does not read any data but it is parsed and planned
+func checkWhere(c client.Client, schema []*InfluxSchDb) error {
+	if len(seriesWhere) == 0 {
+		return nil
+	}
+	for _, db := range schema {
+		for _, rp := range db.Rps {
+			for m := range rp.Measurements {
+				q := client.Query{
+					Command:         fmt.Sprintf("select * from \"%s\" where time < 0%s limit 1", m, whereClause()),
+					Database:        db.Name,
+					RetentionPolicy: rp.Name,
+				}
+				response, err := c.Query(q)
+				if err != nil {
+					return err
+				}
+				return response.Error()
+			}
+		}
+	}
+	return nil
+}
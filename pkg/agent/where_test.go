@@ -0,0 +1,35 @@
+package agent
+
+import "testing"
+
+func TestCheckPredicate(t *testing.T) {
+	valid := []string{
+		"",
+		"host = 'a'",
+		"(host = 'a' or host = 'b') and region = 'eu'",
+		"host =~ /^(web|db)\\)/",
+		"host = 'a) or (host = b'",
+		"\"host\" = 'time'",
+		"timezone = 'utc'",
+	}
+	for _, w := range valid {
+		if err := checkPredicate(w); err != nil {
+			t.Errorf("%s rejected: %s", w, err)
+		}
+	}
+	invalid := []string{
+		"host='a') or (host='b'",
+		"host = 'a')",
+		"(host = 'a'",
+		"host = 'a'; drop database telegraf",
+		"host = 'a",
+		"time > now() - 1h",
+		"host = 'a' or TIME > 0",
+		"\"time\" > 0",
+	}
+	for _, w := range invalid {
+		if err := checkPredicate(w); err == nil {
+			t.Errorf("%s accepted", w)
+		}
+	}
+}
@@ -13,17 +13,41 @@ type GeneralConfig struct {
 	LogLevel               string        `mapstructure:"loglevel"`
 	SyncMode               string        `mapstructure:"sync-mode"`
 	CheckInterval          time.Duration `mapstructure:"check-interval"`
+	DownThreshold          int           `mapstructure:"down-threshold"`
+	UpThreshold            int           `mapstructure:"up-threshold"`
 	MinSyncInterval        time.Duration `mapstructure:"min-sync-interval"`
+	RecoveryPrePadding     time.Duration `mapstructure:"recovery-pre-padding"`
+	RecoveryPostPadding    time.Duration `mapstructure:"recovery-post-padding"`
+	RecoveryMaxRetries     int           `mapstructure:"recovery-max-retries"`
+	RetryInterval          time.Duration `mapstructure:"retry-interval"`
+	RetryMaxBackoff        time.Duration `mapstructure:"retry-max-backoff"`
 	MasterDB               string        `mapstructure:"master-db"`
 	SlaveDB                string        `mapstructure:"slave-db"`
+	ClusterNodes           []string      `mapstructure:"cluster-nodes"`
 	InitialReplication     string        `mapstructure:"initial-replication"`
 	MonitorRetryInterval   time.Duration `mapstructure:"monitor-retry-interval"`
 	DataChunkDuration      time.Duration `mapstructure:"data-chuck-duration"`
+	DataChunkTargetPoints  int64         `mapstructure:"data-chunk-target-points"`
+	DataChunkMinDuration   time.Duration `mapstructure:"data-chunk-min-duration"`
+	DataChunkMaxDuration   time.Duration `mapstructure:"data-chunk-max-duration"`
+	BisectMinWindow        time.Duration `mapstructure:"bisect-min-window"`
+	CopyOrder              string        `mapstructure:"copy-order"`
+	FieldTypeConflict      string        `mapstructure:"field-type-conflict"`
+	Where                  string        `mapstructure:"where"`
+	DownsampleInterval     time.Duration `mapstructure:"downsample-interval"`
+	DownsampleRP           string        `mapstructure:"downsample-rp"`
+	DownsampleAggregate    string        `mapstructure:"downsample-aggregate"`
+	DownsampleFieldAggs    []string      `mapstructure:"downsample-field-aggregates"`
 	MaxRetentionInterval   time.Duration `mapstructure:"max-retention-interval"`
 	RWMaxRetries           int           `mapstructure:"rw-max-retries"`
 	RWRetryDelay           time.Duration `mapstructure:"rw-retry-delay"`
 	NumWorkers             int           `mapstructure:"num-workers"`
+	MaxWorkersPerSource    int           `mapstructure:"max-workers-per-source"`
+	MaxWorkersPerDest      int           `mapstructure:"max-workers-per-destination"`
 	MaxPointsOnSingleWrite int           `mapstructure:"max-points-on-single-write"`
+	MaxPointsPerSecond     int64         `mapstructure:"max-points-per-second"`
+	MaxBytesPerSecond      int64         `mapstructure:"max-bytes-per-second"`
+	MaxQueriesPerSecond    int64         `mapstructure:"max-queries-per-second"`
 }
 
 //SelfMonConfig configuration for self monitoring
@@ -52,13 +76,26 @@ type InfluxDB struct {
 	Timeout     time.Duration `mapstructure:"timeout"`
 }
 
+//TransformRule changes the points copied from the measurements matching the Measurement regex,
+//tag and field renames are "old=new" pairs and added tags "key=value" pairs
+type TransformRule struct {
+	Measurement  string   `mapstructure:"measurement"`
+	Rename       string   `mapstructure:"rename"`
+	RenameTags   []string `mapstructure:"rename-tags"`
+	DropTags     []string `mapstructure:"drop-tags"`
+	AddTags      []string `mapstructure:"add-tags"`
+	RenameFields []string `mapstructure:"rename-fields"`
+	DropFields   []string `mapstructure:"drop-fields"`
+}
+
 //Config Main Configuration struct
 type Config struct {
 	General GeneralConfig
 	//Database DatabaseCfg
 	//Selfmon  SelfMonConfig
 	HTTP        HTTPConfig
-	InfluxArray []*InfluxDB `mapstructure:"influxdb"`
+	InfluxArray []*InfluxDB      `mapstructure:"influxdb"`
+	Transforms  []*TransformRule `mapstructure:"transform"`
 }
 
 //var MainConfig Config
@@ -28,11 +28,11 @@ var (
 	httpPort   = "0.0.0.0:4090"
 	appdir     = os.Getenv("PWD")
 	//homeDir    string
-	pidFile string
-	logDir  = filepath.Join(appdir, "log")
-	logMode = "console"
-	confDir = filepath.Join(appdir, "conf")
-	//dataDir    = confDir
+	pidFile    string
+	logDir     = filepath.Join(appdir, "log")
+	logMode    = "console"
+	confDir    = filepath.Join(appdir, "conf")
+	dataDir    string
 	configFile = filepath.Join(confDir, "syncflux.toml")
 	//
 	action       = "hamonitor"
@@ -48,7 +48,15 @@ var (
 	endtimestr   string
 	endtime      = time.Now()
 	fulltime     bool
+	resume       bool
+	apiAddr      string
 	chunktimestr string
+	copyorder    string
+	rulesFile    string
+	wherestr     string
+	dsinterval   string
+	dsrp         string
+	dsaggregate  string
 	//log level
 
 	loginfo  bool
@@ -78,7 +86,7 @@ func flags() *flag.FlagSet {
 	var f flag.FlagSet
 	f.BoolVar(&getversion, "version", getversion, "display the version")
 	//--------------------------------------------------------------
-	f.StringVar(&action, "action", action, "hamonitor(default),copy,fullcopy,replicaschema")
+	f.StringVar(&action, "action", action, "hamonitor(default),copy,fullcopy,replicaschema,reconcile")
 	f.StringVar(&master, "master", master, "choose master ID from all those in the config file where to get data (override the master-db parameter in the config file)")
 	f.StringVar(&slave, "slave", slave, "choose master ID from all those in the config file where to write data (override the slave-db parameter in the config file)")
 	f.StringVar(&actiondb, "db", actiondb, "set the db where to play")
@@ -86,10 +94,18 @@ func flags() *flag.FlagSet {
 	f.StringVar(&actionmeas, "meas", actionmeas, "set the meas where to play")
 	f.StringVar(&newdb, "newdb", newdb, "set the db to work on")
 	f.StringVar(&newrp, "newrp", newrp, "set the rp to work on")
-	f.StringVar(&chunktimestr, "chunk", chunktimestr, "set RW chuck periods as in the data-chuck-duration config param")
+	f.StringVar(&chunktimestr, "chunk", chunktimestr, "set RW chuck periods as in the data-chuck-duration config param (data-chunk-max-duration on adaptive chunk sizing)")
+	f.StringVar(&wherestr, "where", wherestr, "set an InfluxQL predicate to filter the series to copy as f.e. \"host =~ /^web/\" (override the where parameter in the config file)")
+	f.StringVar(&dsinterval, "downsample", dsinterval, "copy aggregates grouped by time of this interval instead of raw data (override the downsample-interval parameter in the config file)")
+	f.StringVar(&dsrp, "downsample-rp", dsrp, "set the rp where to write the downsampled data (override the downsample-rp parameter in the config file)")
+	f.StringVar(&dsaggregate, "aggregate", dsaggregate, "set the default aggregate for downsampling mean(default),median,stddev,spread,sum,count,mode,first,last,max,min (override the downsample-aggregate parameter in the config file)")
+	f.StringVar(&rulesFile, "rules", rulesFile, "transform rules file with [[transform]] sections applied after the config file ones")
+	f.StringVar(&copyorder, "order", copyorder, "set the chunk copy order newest-first(default),oldest-first (override the copy-order parameter in the config file)")
 	f.StringVar(&starttimestr, "start", starttimestr, "set the starttime to do action (no valid in hamonitor) default now-24h")
 	f.StringVar(&endtimestr, "end", endtimestr, "set the endtime do action (no valid in hamonitor) default now")
 	f.BoolVar(&fulltime, "full", fulltime, "copy full database or now()- max-retention-interval if greater retention policy")
+	f.StringVar(&apiAddr, "api", apiAddr, "bind address where to start the HTTP API while running copy,fullcopy or reconcile actions (disabled by default)")
+	f.BoolVar(&resume, "resume", resume, "resume a previous interrupted copy/fullcopy from its checkpoint in the data directory")
 	//  -v = Info
 	//  -vv =  debug
 	//  -vvv = trace
@@ -102,7 +118,7 @@ func flags() *flag.FlagSet {
 	f.StringVar(&logMode, "logmode", logDir, "log mode [console/file] default console")
 	f.StringVar(&logDir, "logs", logDir, "log directory (only apply if action=hamonitor and logmode=file)")
 	//f.StringVar(&homeDir, "home", homeDir, "home directory")
-	//f.StringVar(&dataDir, "data", dataDir, "Data directory")
+	f.StringVar(&dataDir, "data", dataDir, "data directory where to persist the HA cluster state and the copy checkpoints (override the datadir parameter in the config file)")
 	f.StringVar(&pidFile, "pidfile", pidFile, "path to pid file")
 	//---------------------------------------------------------------
 	f.Usage = func() {
@@ -165,6 +181,14 @@ func init() {
 		log.Infof("Set logdir %s from Command Line parameter", logDir)
 	}
 
+	if len(dataDir) == 0 {
+		dataDir = cfg.General.DataDir
+		if len(dataDir) == 0 {
+			dataDir = filepath.Join(appdir, "data")
+		}
+	}
+	cfg.General.DataDir = dataDir
+
 	//default output to console
 	log.Out = os.Stdout
 
@@ -216,11 +240,129 @@ func init() {
 	if cfg.General.MaxPointsOnSingleWrite == 0 {
 		cfg.General.MaxPointsOnSingleWrite = 10000
 	}
+	if cfg.General.DataChunkTargetPoints > 0 {
+		if cfg.General.DataChunkMinDuration < time.Second {
+			cfg.General.DataChunkMinDuration = time.Minute
+		}
+		if cfg.General.DataChunkMaxDuration == 0 {
+			cfg.General.DataChunkMaxDuration = 24 * time.Hour
+		}
+		if cfg.General.DataChunkMaxDuration < cfg.General.DataChunkMinDuration {
+			log.Errorf("data-chunk-max-duration %s should be greater than data-chunk-min-duration %s", cfg.General.DataChunkMaxDuration, cfg.General.DataChunkMinDuration)
+			os.Exit(1)
+		}
+	}
+	if cfg.General.BisectMinWindow == 0 {
+		cfg.General.BisectMinWindow = time.Minute
+	}
+	if cfg.General.DownThreshold == 0 {
+		cfg.General.DownThreshold = 1
+	}
+	if cfg.General.UpThreshold == 0 {
+		cfg.General.UpThreshold = 1
+	}
+	if cfg.General.RecoveryPrePadding == 0 {
+		cfg.General.RecoveryPrePadding = cfg.General.MinSyncInterval
+	}
+	if cfg.General.RecoveryMaxRetries == 0 {
+		cfg.General.RecoveryMaxRetries = 3
+	}
+	if cfg.General.RetryInterval == 0 {
+		cfg.General.RetryInterval = time.Minute
+	}
+	if cfg.General.RetryMaxBackoff == 0 {
+		cfg.General.RetryMaxBackoff = time.Hour
+	}
+
+	switch cfg.General.SyncMode {
+	case "":
+		cfg.General.SyncMode = "onlyslave"
+	case "onlyslave", "twoway":
+	default:
+		log.Errorf("Unknown sync-mode %s, valid values are onlyslave,twoway", cfg.General.SyncMode)
+		os.Exit(1)
+	}
+
+	if len(copyorder) > 0 {
+		cfg.General.CopyOrder = copyorder
+	}
+	switch cfg.General.CopyOrder {
+	case "":
+		cfg.General.CopyOrder = agent.CopyOrderNewestFirst
+	case agent.CopyOrderNewestFirst, agent.CopyOrderOldestFirst:
+	default:
+		log.Errorf("Unknown copy-order %s, valid values are %s,%s", cfg.General.CopyOrder, agent.CopyOrderNewestFirst, agent.CopyOrderOldestFirst)
+		os.Exit(1)
+	}
+
+	switch cfg.General.FieldTypeConflict {
+	case "":
+		cfg.General.FieldTypeConflict = agent.FieldConflictCoerce
+	case agent.FieldConflictPreserve, agent.FieldConflictCoerce, agent.FieldConflictSkip:
+	default:
+		log.Errorf("Unknown field-type-conflict %s, valid values are %s,%s,%s", cfg.General.FieldTypeConflict, agent.FieldConflictPreserve, agent.FieldConflictCoerce, agent.FieldConflictSkip)
+		os.Exit(1)
+	}
+
+	if len(wherestr) > 0 {
+		cfg.General.Where = wherestr
+	}
+	if len(dsinterval) > 0 {
+		dur, err := time.ParseDuration(dsinterval)
+		if err != nil {
+			log.Errorf("Error in Parse Downsample Interval (%s) : Error %s", dsinterval, err)
+			os.Exit(1)
+		}
+		cfg.General.DownsampleInterval = dur
+	}
+	if len(dsrp) > 0 {
+		cfg.General.DownsampleRP = dsrp
+	}
+	if len(dsaggregate) > 0 {
+		cfg.General.DownsampleAggregate = dsaggregate
+	}
+
+	if len(rulesFile) > 0 {
+		rv := viper.New()
+		rv.SetConfigFile(rulesFile)
+		if err := rv.ReadInConfig(); err != nil {
+			log.Errorf("Fatal error rules file: %s \n", err)
+			os.Exit(1)
+		}
+		rules := []*config.TransformRule{}
+		if err := rv.UnmarshalKey("transform", &rules); err != nil {
+			log.Errorf("Fatal error rules file: %s \n", err)
+			os.Exit(1)
+		}
+		cfg.Transforms = append(cfg.Transforms, rules...)
+	}
+	if len(cfg.Transforms) > 0 && cfg.General.SyncMode == "twoway" {
+		// master can not be recovered from transformed slave data
+		log.Errorf("Transform rules can not be used with sync-mode twoway")
+		os.Exit(1)
+	}
+	if len(cfg.Transforms) > 0 && action == "hamonitor" {
+		// with more than one slave, a slave with transformed data could be the recovery source
+		slaves := 0
+		for _, n := range cfg.General.ClusterNodes {
+			if n != master {
+				slaves++
+			}
+		}
+		if slaves > 1 {
+			log.Errorf("Transform rules can not be used with more than one slave in cluster-nodes")
+			os.Exit(1)
+		}
+	}
+	if err := agent.SetTransforms(cfg.Transforms); err != nil {
+		log.Errorf("Fatal error on transform rules: %s", err)
+		os.Exit(1)
+	}
 
 	//needed to create SQLDB when SQLite and debug log
 	config.SetLogger(log)
 	config.SetLogDir(logDir)
-	//config.SetDirs(dataDir, logDir, confDir)
+	config.SetDirs(dataDir, logDir, confDir)
 
 	webui.SetLogger(log)
 	webui.SetLogDir(logDir)
@@ -228,7 +370,7 @@ func init() {
 	agent.SetLogger(log)
 
 	//
-	log.Infof("Set Default directories : \n   - Exec: %s\n   - Config: %s\n   -Logs: %s\n", appdir, confDir, logDir)
+	log.Infof("Set Default directories : \n   - Exec: %s\n   - Config: %s\n   -Logs: %s\n   -Data: %s\n", appdir, confDir, logDir, dataDir)
 }
 
 func main() {
@@ -238,20 +380,22 @@ func main() {
 	}()
 	writePIDFile()
 	//Init BD config
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 	go func() {
 		select {
 		case sig := <-c:
 			switch sig {
 			case syscall.SIGTERM:
-				log.Infof("Received TERM signal")
-				agent.End()
+				log.Infof("Received TERM signal, cancelling running jobs...")
+				elapsed, _ := agent.End()
+				log.Infof("Running jobs cancelled in %s", elapsed.String())
 				log.Infof("Exiting for requested user SIGTERM")
 				os.Exit(1)
 			case syscall.SIGINT:
-				log.Infof("Received INT signal")
-				agent.End()
+				log.Infof("Received INT signal, cancelling running jobs...")
+				elapsed, _ := agent.End()
+				log.Infof("Running jobs cancelled in %s", elapsed.String())
 				log.Infof("Exiting for requested user SIGINT")
 				os.Exit(1)
 			case syscall.SIGHUP:
@@ -288,6 +432,57 @@ func main() {
 			os.Exit(1)
 		}
 		agent.MainConfig.General.DataChunkDuration = dur
+		if agent.MainConfig.General.DataChunkTargetPoints > 0 {
+			// on adaptive mode data is read in chunks of the max duration
+			if dur < agent.MainConfig.General.DataChunkMinDuration {
+				log.Errorf("chunk %s should be greater than data-chunk-min-duration %s", dur, agent.MainConfig.General.DataChunkMinDuration)
+				os.Exit(1)
+			}
+			log.Infof("Set data-chunk-max-duration %s from Command Line parameters", dur)
+			agent.MainConfig.General.DataChunkMaxDuration = dur
+		}
+	}
+
+	agent.SetRateLimits(agent.RateLimits{
+		PointsPerSecond:  agent.MainConfig.General.MaxPointsPerSecond,
+		BytesPerSecond:   agent.MainConfig.General.MaxBytesPerSecond,
+		QueriesPerSecond: agent.MainConfig.General.MaxQueriesPerSecond,
+	})
+
+	switch action {
+	case "copy", "fullcopy", "reconcile":
+		if err := agent.SetWhere(agent.MainConfig.General.Where); err != nil {
+			log.Errorf("%s", err)
+			os.Exit(1)
+		}
+	default:
+		if len(agent.MainConfig.General.Where) > 0 {
+			log.Warnf("where filter is only applied on copy, fullcopy and reconcile actions")
+		}
+	}
+	if agent.MainConfig.General.DownsampleInterval > 0 {
+		switch action {
+		case "copy", "fullcopy":
+			err := agent.SetDownsample(&agent.Downsample{
+				Interval:        agent.MainConfig.General.DownsampleInterval,
+				RP:              agent.MainConfig.General.DownsampleRP,
+				Aggregate:       agent.MainConfig.General.DownsampleAggregate,
+				FieldAggregates: agent.MainConfig.General.DownsampleFieldAggs,
+			})
+			if err != nil {
+				log.Errorf("%s", err)
+				os.Exit(1)
+			}
+		default:
+			log.Warnf("downsampling is only applied on copy and fullcopy actions")
+		}
+	}
+
+	if len(apiAddr) > 0 && action != "hamonitor" {
+		// enables to change rate limits while copying
+		httpcfg := agent.MainConfig.HTTP
+		httpcfg.BindAddr = apiAddr
+		go webui.WebServer("", apiAddr, &httpcfg, agent.MainConfig.General.InstanceID)
 	}
 
 	switch action {
@@ -295,12 +490,14 @@ func main() {
 		agent.HAMonitorStart(master, slave)
 		webui.WebServer("", httpPort, &agent.MainConfig.HTTP, agent.MainConfig.General.InstanceID)
 	case "copy":
-		agent.Copy(master, slave, actiondb, newdb, actionrp, newrp, actionmeas, starttime, endtime, fulltime)
+		agent.Copy(master, slave, actiondb, newdb, actionrp, newrp, actionmeas, starttime, endtime, fulltime, resume)
 	case "move":
 	case "replicaschema":
 		agent.ReplSch(master, slave, actiondb, newdb, actionrp, newrp, actionmeas)
 	case "fullcopy":
-		agent.SchCopy(master, slave, actiondb, newdb, actionrp, newrp, actionmeas, starttime, endtime, fulltime)
+		agent.SchCopy(master, slave, actiondb, newdb, actionrp, newrp, actionmeas, starttime, endtime, fulltime, resume)
+	case "reconcile":
+		agent.Reconcile(master, slave, actiondb, newdb, actionrp, newrp, actionmeas, starttime, endtime, fulltime)
 	default:
 		fmt.Printf("Unknown action: %s", action)
 	}
@@ -1,6 +1,9 @@
 package webui
 
 import (
+	"fmt"
+	"strconv"
+
 	//"github.com/go-macaron/binding"
 	"github.com/toni-moreno/syncflux/pkg/agent"
 	"gopkg.in/macaron.v1"
@@ -16,46 +19,235 @@ func NewAPI(m *macaron.Macaron) error {
 		m.Get("/health/:id" /*reqSignedIn,*/, HealthID)
 		m.Post("/action/:id", reqSignedIn, Action)
 		m.Get("/queryactive", QueryActive)
+		m.Get("/retryqueue", RetryQueueList)
+		m.Post("/retryqueue/:id/retry", reqSignedIn, RetryQueueRetry)
+		m.Delete("/retryqueue/:id", reqSignedIn, RetryQueueDiscard)
+		m.Get("/ratelimit", RateLimitGet)
+		m.Post("/ratelimit", reqSignedIn, RateLimitSet)
+		m.Get("/jobs", JobsList)
+		m.Post("/jobs/:id/cancel", reqSignedIn, JobCancel)
 	})
 
 	return nil
 }
 
+// cluster returns the running cluster or replies 503 if it is not initialized yet
+func cluster(ctx *Context) *agent.HACluster {
+	c := agent.GetCluster()
+	if c == nil {
+		ctx.JSON(503, "cluster not initialized yet")
+	}
+	return c
+}
+
 func HealthCluster(ctx *Context) {
 	log.Info("API: /healthcluster")
 
-	ctx.JSON(200, agent.Cluster.GetStatus())
+	c := cluster(ctx)
+	if c == nil {
+		return
+	}
+
+	ctx.JSON(200, c.GetStatus())
 }
 
 func QueryActive(ctx *Context) {
 	log.Info("API: /queryactive")
 
-	status := agent.Cluster.GetStatus()
+	c := cluster(ctx)
+	if c == nil {
+		return
+	}
+
+	status := c.GetStatus()
 
 	active := []string{}
 
-	if status.MasterState {
-		active = append(active, status.MID)
-	}
-	if status.SlaveState {
-		active = append(active, status.SID)
+	for _, n := range status.Nodes {
+		if n.State && !n.Maintenance {
+			active = append(active, n.ID)
+		}
 	}
 
 	ctx.JSON(200, active)
 }
 
 func HealthID(ctx *Context) {
-	log.Info("Doing Action")
+	id := ctx.Params(":id")
+	log.Infof("API: /health/%s", id)
 
-	ctx.JSON(200, "hola")
+	c := cluster(ctx)
+	if c == nil {
+		return
+	}
+
+	status := c.GetNodeStatus(id)
+	if status == nil {
+		ctx.JSON(404, fmt.Sprintf("node %s not found in cluster", id))
+		return
+	}
+	ctx.JSON(200, status)
 }
 
-// ExportObject export object
+// Action executes actions on the cluster
+//   - maintenance: ?node=<id>&state=[on|off] begins/ends maintenance mode on node
 func Action(ctx *Context) {
 	id := ctx.Params(":id")
 
 	log.Infof("Doing Action %s", id)
 
-	ctx.JSON(200, "hola")
+	c := cluster(ctx)
+	if c == nil {
+		return
+	}
+
+	switch id {
+	case "maintenance":
+		node := ctx.Query("node")
+		var err error
+		switch ctx.Query("state") {
+		case "on", "":
+			err = c.StartMaintenance(node)
+		case "off":
+			err = c.EndMaintenance(node)
+		default:
+			ctx.JSON(400, fmt.Sprintf("unknown maintenance state %s, valid values are on,off", ctx.Query("state")))
+			return
+		}
+		if err != nil {
+			ctx.JSON(400, err.Error())
+			return
+		}
+		ctx.JSON(200, c.GetNodeStatus(node))
+	default:
+		ctx.JSON(404, fmt.Sprintf("unknown action %s", id))
+	}
+}
+
+// RetryQueueList lists the chunks pending to retry
+func RetryQueueList(ctx *Context) {
+	log.Info("API: /retryqueue")
+
+	c := cluster(ctx)
+	if c == nil {
+		return
+	}
+
+	if c.RetryQueue == nil {
+		ctx.JSON(404, "retry queue not enabled")
+		return
+	}
+	ctx.JSON(200, c.RetryQueue.List())
+}
+
+// RetryQueueRetry forces the retry of a pending chunk
+func RetryQueueRetry(ctx *Context) {
+	id := ctx.Params(":id")
+	log.Infof("API: /retryqueue/%s/retry", id)
+
+	c := cluster(ctx)
+	if c == nil {
+		return
+	}
+
+	if c.RetryQueue == nil {
+		ctx.JSON(404, "retry queue not enabled")
+		return
+	}
+	i, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		ctx.JSON(400, err.Error())
+		return
+	}
+	if err := c.RetryQueue.Retry(i); err != nil {
+		ctx.JSON(404, err.Error())
+		return
+	}
+	ctx.JSON(200, "OK")
+}
+
+// RetryQueueDiscard removes a pending chunk from the retry queue
+func RetryQueueDiscard(ctx *Context) {
+	id := ctx.Params(":id")
+	log.Infof("API: /retryqueue/%s discard", id)
+
+	c := cluster(ctx)
+	if c == nil {
+		return
+	}
+
+	if c.RetryQueue == nil {
+		ctx.JSON(404, "retry queue not enabled")
+		return
+	}
+	i, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		ctx.JSON(400, err.Error())
+		return
+	}
+	if err := c.RetryQueue.Discard(i); err != nil {
+		ctx.JSON(404, err.Error())
+		return
+	}
+	ctx.JSON(200, "OK")
+}
 
+// RateLimitGet returns the current copy rate limits
+func RateLimitGet(ctx *Context) {
+	log.Info("API: /ratelimit")
+
+	ctx.JSON(200, agent.GetRateLimits())
+}
+
+// RateLimitSet changes the copy rate limits with ?points=<n>&bytes=<n>&queries=<n>
+// per second (0 means unlimited), the not set ones are not changed
+func RateLimitSet(ctx *Context) {
+	log.Info("API: /ratelimit set")
+
+	rl := agent.GetRateLimits()
+	for _, l := range []struct {
+		param string
+		value *int64
+	}{
+		{"points", &rl.PointsPerSecond},
+		{"bytes", &rl.BytesPerSecond},
+		{"queries", &rl.QueriesPerSecond},
+	} {
+		v := ctx.Query(l.param)
+		if len(v) == 0 {
+			continue
+		}
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || i < 0 {
+			ctx.JSON(400, fmt.Sprintf("invalid %s rate limit %s", l.param, v))
+			return
+		}
+		*l.value = i
+	}
+	agent.SetRateLimits(rl)
+	ctx.JSON(200, rl)
+}
+
+// JobsList returns the running copy jobs
+func JobsList(ctx *Context) {
+	log.Info("API: /jobs")
+
+	ctx.JSON(200, agent.ListJobs())
+}
+
+// JobCancel cancels a running copy job, its running batches finish and its progress is saved
+func JobCancel(ctx *Context) {
+	id := ctx.Params(":id")
+	log.Infof("API: /jobs/%s/cancel", id)
+
+	i, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		ctx.JSON(400, err.Error())
+		return
+	}
+	if err := agent.CancelJob(i); err != nil {
+		ctx.JSON(404, err.Error())
+		return
+	}
+	ctx.JSON(200, "OK")
 }
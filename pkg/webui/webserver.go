@@ -144,7 +144,7 @@ func WebServer(publicPath string, bindAddr string, cfg *config.HTTPConfig, id st
 			},
 		}},*/
 		// Delims sets the action delimiters to the specified strings. Defaults are ["{{", "}}"].
-		Delims: macaron.Delims{"{{", "}}"},
+		Delims: macaron.Delims{Left: "{{", Right: "}}"},
 		// Appends the given charset to the Content-Type header. Default is "UTF-8".
 		Charset: "UTF-8",
 		// Outputs human readable JSON. Default is false.